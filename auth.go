@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/PremiereGlobal/vault-to-envs/pkg/vaulttoenvs"
+)
+
+// getAuthMethod builds the Vault auth method from the command parameters
+// Returns nil for token authentication
+func getAuthMethod() (vaulttoenvs.AuthMethod, error) {
+
+	method := config.GetString("auth-method")
+	if config.GetString("wrapped-token") != "" && method != "token" && method != "" && method != "approle" {
+		return nil, fmt.Errorf("--wrapped-token can only be used with the token or approle auth methods")
+	}
+
+	switch method {
+	case "token", "":
+		if config.GetString("wrapped-token") != "" {
+			log.Debug("Vault Token: unwrapping wrapped token")
+			return &vaulttoenvs.WrappedTokenAuth{
+				WrappingToken: config.GetString("wrapped-token"),
+			}, nil
+		}
+		return nil, nil
+	case "approle":
+		if config.GetString("approle-role-id") == "" && config.GetString("approle-role-id-file") == "" {
+			return nil, fmt.Errorf("--approle-role-id or --approle-role-id-file must be provided (or env var VAULT_ROLE_ID or VAULT_ROLE_ID_FILE)")
+		}
+
+		// secret_id is optional, so catch an empty variable here rather than as a failed login
+		for _, env := range []string{"VAULT_SECRET_ID", "VAULT_SECRET_ID_FILE"} {
+			if value, ok := os.LookupEnv(env); ok && value == "" {
+				return nil, fmt.Errorf("Env var %s is set but empty", env)
+			}
+		}
+
+		if config.GetString("wrapped-token") != "" && (config.GetString("approle-secret-id") != "" || config.GetString("approle-secret-id-file") != "") {
+			return nil, fmt.Errorf("Only one of --wrapped-token OR --approle-secret-id/--approle-secret-id-file can be set")
+		}
+
+		if config.GetString("approle-secret-id") != "" {
+			log.Debug("AppRole secret_id: provided")
+		} else if config.GetString("approle-secret-id-file") != "" {
+			log.Debugf("AppRole secret_id: from file %s", config.GetString("approle-secret-id-file"))
+		} else if config.GetString("wrapped-token") != "" {
+			log.Debug("AppRole secret_id: unwrapping wrapped token")
+		} else {
+			log.Debug("AppRole secret_id: not provided")
+		}
+
+		return &vaulttoenvs.AppRoleAuth{
+			RoleID:                config.GetString("approle-role-id"),
+			RoleIDFile:            config.GetString("approle-role-id-file"),
+			SecretID:              config.GetString("approle-secret-id"),
+			SecretIDFile:          config.GetString("approle-secret-id-file"),
+			SecretIDWrappingToken: config.GetString("wrapped-token"),
+			MountPath:             config.GetString("approle-mount"),
+		}, nil
+	case "kubernetes":
+		if config.GetString("kubernetes-role") == "" {
+			return nil, fmt.Errorf("--kubernetes-role must be provided (or env var VAULT_KUBERNETES_ROLE)")
+		}
+		return &vaulttoenvs.KubernetesAuth{
+			Role:      config.GetString("kubernetes-role"),
+			JWTPath:   config.GetString("kubernetes-jwt-path"),
+			MountPath: config.GetString("kubernetes-mount"),
+		}, nil
+	case "jwt":
+		if config.GetString("jwt") == "" && config.GetString("jwt-file") == "" {
+			return nil, fmt.Errorf("--jwt or --jwt-file must be provided (or env var VAULT_JWT or VAULT_JWT_FILE)")
+		}
+		return &vaulttoenvs.JWTAuth{
+			Role:      config.GetString("jwt-role"),
+			JWT:       config.GetString("jwt"),
+			JWTFile:   config.GetString("jwt-file"),
+			MountPath: config.GetString("jwt-mount"),
+		}, nil
+	case "aws":
+		return &vaulttoenvs.AWSAuth{
+			Role:        config.GetString("aws-role"),
+			MountPath:   config.GetString("aws-mount"),
+			HeaderValue: config.GetString("aws-header-value"),
+			Region:      config.GetString("aws-sts-region"),
+		}, nil
+	case "cert":
+		if config.GetString("vault-client-cert") == "" || config.GetString("vault-client-key") == "" {
+			return nil, fmt.Errorf("--vault-client-cert and --vault-client-key must be provided (or env var VAULT_CLIENT_CERT and VAULT_CLIENT_KEY)")
+		}
+		return &vaulttoenvs.CertAuth{
+			Name:      config.GetString("cert-name"),
+			MountPath: config.GetString("cert-mount"),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported auth method '%s'", method)
+}
+
+// findToken looks up the Vault token in the same order as the Vault CLI
+// The flag is passed on its own so that the env var is reported as the source when it's used
+func findToken() string {
+
+	flagToken := ""
+	if app.PersistentFlags().Changed("vault-token") {
+		flagToken = config.GetString("vault-token")
+	}
+
+	token, source, err := vaulttoenvs.FindToken(flagToken, config.GetString("vault-token-file"))
+	if err != nil {
+		log.Fatalf("%v: --vault-token or --vault-token-file must be provided (or env var VAULT_TOKEN or VAULT_TOKEN_FILE), or log in with the Vault CLI", err)
+	}
+
+	if source == "provided token" {
+		source = "--vault-token flag"
+	}
+	log.Debugf("Vault Token: from %s", source)
+
+	return token
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/PremiereGlobal/vault-to-envs/pkg/vaulttoenvs"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -21,24 +22,186 @@ func initializeCommandParameters() {
 		},
 	}
 
+	var cmdValidate = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the secret config without contacting Vault",
+		Long:  `Validate the secret config without contacting Vault, reporting every problem found`,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runValidate()
+		},
+	}
+
+	var cmdExec = &cobra.Command{
+		Use:   "exec [flags] -- command [args...]",
+		Short: "Run a command with the secrets added to its environment",
+		Long: `Run a command with the secrets added to its environment.  By default v2e is replaced by the command,
+so the command receives signals directly.  With --supervise, the command is run as a child process`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runExec(args)
+		},
+	}
+
+	// Flags after the command belong to the command
+	cmdExec.Flags().SetInterspersed(false)
+
+	cmdExec.Flags().BoolP("supervise", "", false, "Run the command as a child process, rather than replacing v2e")
+	config.BindPFlag("supervise", cmdExec.Flags().Lookup("supervise"))
+	config.BindEnv("supervise", "EXEC_SUPERVISE")
+
+	cmdExec.Flags().StringP("max-ttl-policy", "", vaulttoenvs.MaxTTLWarn, "When a lease or the Vault token reaches its max TTL with --supervise: warn, signal (SIGTERM) or restart the command")
+	config.BindPFlag("max-ttl-policy", cmdExec.Flags().Lookup("max-ttl-policy"))
+	config.BindEnv("max-ttl-policy", "MAX_TTL_POLICY")
+
+	cmdExec.Flags().BoolP("revoke-on-exit", "", false, "Revoke the leases of dynamic secrets once the command exits (requires --supervise)")
+	config.BindPFlag("revoke-on-exit", cmdExec.Flags().Lookup("revoke-on-exit"))
+	config.BindEnv("revoke-on-exit", "REVOKE_ON_EXIT")
+
 	app = cmdRoot
+	app.AddCommand(cmdValidate)
+	app.AddCommand(cmdExec)
 
 	app.PersistentFlags().StringP("vault-address", "", "", "Vault address (ex: https://vault.my-domain.com:8200)")
 	config.BindPFlag("vault-address", app.PersistentFlags().Lookup("vault-address"))
 	config.BindEnv("vault-address", "VAULT_ADDR")
 
+	app.PersistentFlags().StringP("vault-namespace", "", "", "Vault Enterprise namespace")
+	config.BindPFlag("vault-namespace", app.PersistentFlags().Lookup("vault-namespace"))
+	config.BindEnv("vault-namespace", "VAULT_NAMESPACE")
+
 	app.PersistentFlags().StringP("vault-token", "", "", "Vault token")
 	config.BindPFlag("vault-token", app.PersistentFlags().Lookup("vault-token"))
 	config.BindEnv("vault-token", "VAULT_TOKEN")
 
-	app.PersistentFlags().StringP("secret-config", "", "", "The secret config string to use")
+	app.PersistentFlags().StringP("vault-token-file", "", "", "File containing the Vault token")
+	config.BindPFlag("vault-token-file", app.PersistentFlags().Lookup("vault-token-file"))
+	config.BindEnv("vault-token-file", "VAULT_TOKEN_FILE")
+
+	app.PersistentFlags().StringP("vault-cacert", "", "", "PEM-encoded CA cert file used to verify the Vault server")
+	config.BindPFlag("vault-cacert", app.PersistentFlags().Lookup("vault-cacert"))
+	config.BindEnv("vault-cacert", "VAULT_CACERT")
+
+	app.PersistentFlags().StringP("vault-capath", "", "", "Directory of PEM-encoded CA cert files used to verify the Vault server")
+	config.BindPFlag("vault-capath", app.PersistentFlags().Lookup("vault-capath"))
+	config.BindEnv("vault-capath", "VAULT_CAPATH")
+
+	app.PersistentFlags().StringP("vault-client-cert", "", "", "PEM-encoded client certificate for TLS authentication")
+	config.BindPFlag("vault-client-cert", app.PersistentFlags().Lookup("vault-client-cert"))
+	config.BindEnv("vault-client-cert", "VAULT_CLIENT_CERT")
+
+	app.PersistentFlags().StringP("vault-client-key", "", "", "PEM-encoded client key for TLS authentication")
+	config.BindPFlag("vault-client-key", app.PersistentFlags().Lookup("vault-client-key"))
+	config.BindEnv("vault-client-key", "VAULT_CLIENT_KEY")
+
+	app.PersistentFlags().StringP("vault-tls-server-name", "", "", "SNI host name to use when connecting to Vault")
+	config.BindPFlag("vault-tls-server-name", app.PersistentFlags().Lookup("vault-tls-server-name"))
+	config.BindEnv("vault-tls-server-name", "VAULT_TLS_SERVER_NAME")
+
+	app.PersistentFlags().BoolP("vault-skip-verify", "", false, "Do not verify the Vault server certificate (insecure)")
+	config.BindPFlag("vault-skip-verify", app.PersistentFlags().Lookup("vault-skip-verify"))
+	config.BindEnv("vault-skip-verify", "VAULT_SKIP_VERIFY")
+
+	app.PersistentFlags().StringP("wrapped-token", "", "", "Response-wrapped Vault token (or AppRole secret_id with --auth-method approle) to unwrap")
+	config.BindPFlag("wrapped-token", app.PersistentFlags().Lookup("wrapped-token"))
+	config.BindEnv("wrapped-token", "VAULT_WRAPPED_TOKEN")
+
+	app.PersistentFlags().StringP("auth-method", "", "token", "Vault auth method to use (token, approle, kubernetes, jwt, aws, cert)")
+	config.BindPFlag("auth-method", app.PersistentFlags().Lookup("auth-method"))
+	config.BindEnv("auth-method", "VAULT_AUTH_METHOD")
+
+	app.PersistentFlags().StringP("approle-role-id", "", "", "AppRole role_id")
+	config.BindPFlag("approle-role-id", app.PersistentFlags().Lookup("approle-role-id"))
+	config.BindEnv("approle-role-id", "VAULT_ROLE_ID")
+
+	app.PersistentFlags().StringP("approle-role-id-file", "", "", "File containing the AppRole role_id")
+	config.BindPFlag("approle-role-id-file", app.PersistentFlags().Lookup("approle-role-id-file"))
+	config.BindEnv("approle-role-id-file", "VAULT_ROLE_ID_FILE")
+
+	app.PersistentFlags().StringP("approle-secret-id", "", "", "AppRole secret_id")
+	config.BindPFlag("approle-secret-id", app.PersistentFlags().Lookup("approle-secret-id"))
+	config.BindEnv("approle-secret-id", "VAULT_SECRET_ID")
+
+	app.PersistentFlags().StringP("approle-secret-id-file", "", "", "File containing the AppRole secret_id")
+	config.BindPFlag("approle-secret-id-file", app.PersistentFlags().Lookup("approle-secret-id-file"))
+	config.BindEnv("approle-secret-id-file", "VAULT_SECRET_ID_FILE")
+
+	app.PersistentFlags().StringP("approle-mount", "", "approle", "Mount path of the AppRole auth method")
+	config.BindPFlag("approle-mount", app.PersistentFlags().Lookup("approle-mount"))
+	config.BindEnv("approle-mount", "VAULT_APPROLE_MOUNT")
+
+	app.PersistentFlags().StringP("kubernetes-role", "", "", "Kubernetes auth role")
+	config.BindPFlag("kubernetes-role", app.PersistentFlags().Lookup("kubernetes-role"))
+	config.BindEnv("kubernetes-role", "VAULT_KUBERNETES_ROLE")
+
+	app.PersistentFlags().StringP("kubernetes-jwt-path", "", vaulttoenvs.DefaultKubernetesJWTPath, "Path to the Kubernetes service account token")
+	config.BindPFlag("kubernetes-jwt-path", app.PersistentFlags().Lookup("kubernetes-jwt-path"))
+	config.BindEnv("kubernetes-jwt-path", "VAULT_KUBERNETES_JWT_PATH")
+
+	app.PersistentFlags().StringP("kubernetes-mount", "", "kubernetes", "Mount path of the Kubernetes auth method")
+	config.BindPFlag("kubernetes-mount", app.PersistentFlags().Lookup("kubernetes-mount"))
+	config.BindEnv("kubernetes-mount", "VAULT_KUBERNETES_MOUNT")
+
+	app.PersistentFlags().StringP("jwt", "", "", "JWT to log in with")
+	config.BindPFlag("jwt", app.PersistentFlags().Lookup("jwt"))
+	config.BindEnv("jwt", "VAULT_JWT")
+
+	app.PersistentFlags().StringP("jwt-file", "", "", "File containing the JWT to log in with")
+	config.BindPFlag("jwt-file", app.PersistentFlags().Lookup("jwt-file"))
+	config.BindEnv("jwt-file", "VAULT_JWT_FILE")
+
+	app.PersistentFlags().StringP("jwt-role", "", "", "JWT auth role")
+	config.BindPFlag("jwt-role", app.PersistentFlags().Lookup("jwt-role"))
+	config.BindEnv("jwt-role", "VAULT_JWT_ROLE")
+
+	app.PersistentFlags().StringP("jwt-mount", "", "jwt", "Mount path of the JWT auth method")
+	config.BindPFlag("jwt-mount", app.PersistentFlags().Lookup("jwt-mount"))
+	config.BindEnv("jwt-mount", "VAULT_JWT_MOUNT")
+
+	app.PersistentFlags().StringP("aws-role", "", "", "AWS auth role (defaults to the IAM principal name)")
+	config.BindPFlag("aws-role", app.PersistentFlags().Lookup("aws-role"))
+	config.BindEnv("aws-role", "VAULT_AWS_ROLE")
+
+	app.PersistentFlags().StringP("aws-mount", "", "aws", "Mount path of the AWS auth method")
+	config.BindPFlag("aws-mount", app.PersistentFlags().Lookup("aws-mount"))
+	config.BindEnv("aws-mount", "VAULT_AWS_MOUNT")
+
+	app.PersistentFlags().StringP("aws-header-value", "", "", "Value of the X-Vault-AWS-IAM-Server-ID header")
+	config.BindPFlag("aws-header-value", app.PersistentFlags().Lookup("aws-header-value"))
+	config.BindEnv("aws-header-value", "VAULT_AWS_HEADER_VALUE")
+
+	app.PersistentFlags().StringP("aws-sts-region", "", "", "Region of the AWS STS endpoint to sign the login request for")
+	config.BindPFlag("aws-sts-region", app.PersistentFlags().Lookup("aws-sts-region"))
+	config.BindEnv("aws-sts-region", "VAULT_AWS_STS_REGION")
+
+	app.PersistentFlags().StringP("cert-name", "", "", "Certificate auth role to log in against")
+	config.BindPFlag("cert-name", app.PersistentFlags().Lookup("cert-name"))
+	config.BindEnv("cert-name", "VAULT_CERT_NAME")
+
+	app.PersistentFlags().StringP("cert-mount", "", "cert", "Mount path of the certificate auth method")
+	config.BindPFlag("cert-mount", app.PersistentFlags().Lookup("cert-mount"))
+	config.BindEnv("cert-mount", "VAULT_CERT_MOUNT")
+
+	app.PersistentFlags().StringP("secret-config", "", "", "The secret config string to use (JSON or YAML)")
 	config.BindPFlag("secret-config", app.PersistentFlags().Lookup("secret-config"))
 	config.BindEnv("secret-config", "SECRET_CONFIG")
 
-	app.PersistentFlags().StringP("secret-config-file", "", "", "The secret config file to use (takes precedence over --secret-config)")
+	app.PersistentFlags().StringP("secret-config-file", "", "", "The secret config file to use, JSON or YAML (takes precedence over --secret-config)")
 	config.BindPFlag("secret-config-file", app.PersistentFlags().Lookup("secret-config-file"))
 	config.BindEnv("secret-config-file", "SECRET_CONFIG_FILE")
 
+	app.PersistentFlags().StringP("format", "", vaulttoenvs.FormatShell, "Output format (shell, dotenv, json, yaml, fish, powershell or cmd)")
+	config.BindPFlag("format", app.PersistentFlags().Lookup("format"))
+	config.BindEnv("format", "OUTPUT_FORMAT")
+
+	app.PersistentFlags().BoolP("metadata", "", false, "Include the source and lease of each env var (json and yaml formats only)")
+	config.BindPFlag("metadata", app.PersistentFlags().Lookup("metadata"))
+	config.BindEnv("metadata", "OUTPUT_METADATA")
+
+	app.PersistentFlags().BoolP("sort", "", false, "Sort the output by env var name, rather than secret config order")
+	config.BindPFlag("sort", app.PersistentFlags().Lookup("sort"))
+	config.BindEnv("sort", "SORT_ENVS")
+
 	app.PersistentFlags().BoolP("debug", "d", false, "Show debug output")
 	config.BindPFlag("debug", app.PersistentFlags().Lookup("debug"))
 	config.BindEnv("debug", "DEBUG")
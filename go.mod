@@ -6,8 +6,10 @@ replace github.com/PremiereGlobal/vault-to-envs => ./
 
 require (
 	github.com/aws/aws-sdk-go v1.20.20
+	github.com/hashicorp/hcl v1.0.0
 	github.com/hashicorp/vault/api v1.0.2
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
+	gopkg.in/yaml.v2 v2.2.2
 )
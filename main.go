@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/PremiereGlobal/vault-to-envs/pkg/vaulttoenvs"
 	"github.com/sirupsen/logrus"
 )
@@ -18,47 +20,147 @@ func main() {
 }
 
 func run() {
-	if config.GetBool("debug") == true {
-		log.SetLevel(logrus.DebugLevel)
-		log.Debug("Debug level set")
-	} else {
-		log.SetLevel(logrus.InfoLevel)
+	v2e := newVaultToEnvs()
+
+	err := v2e.DisplayEnvExports()
+	if err != nil {
+		fatalError(err)
 	}
+}
 
-	v2eConfig := &vaulttoenvs.Config{
-		VaultAddr:        config.GetString("vault-address"),
-		Debug:            config.GetBool("debug"),
-		SecretConfig:     config.GetString("secret-config"),
-		SecretConfigFile: config.GetString("secret-config-file"),
+// runExec runs the command with the secrets added to its environment, either replacing v2e
+// or, with --supervise, as a child process
+func runExec(args []string) {
+	// Once v2e is replaced by the command, nothing is left to revoke the leases
+	if config.GetBool("revoke-on-exit") && !config.GetBool("supervise") {
+		log.Fatal("--revoke-on-exit requires --supervise")
 	}
 
-	if v2eConfig.VaultAddr == "" {
-		log.Fatal("--vault-address must be provided (or env var VAULT_ADDR)")
+	v2e := newVaultToEnvs()
+
+	if !config.GetBool("supervise") {
+		fatalError(v2e.Exec(args))
 	}
 
-	if config.GetString("vault-token") == "" {
-		log.Fatal("--vault-token must be provided (or env var VAULT_TOKEN)")
+	exitCode, err := v2e.Run(args)
+	if err != nil {
+		fatalError(err)
 	}
+	os.Exit(exitCode)
+}
 
-	if v2eConfig.SecretConfig == "" && v2eConfig.SecretConfigFile == "" {
-		log.Fatal("--secret-config or --secret-config-file must be provided (or env var SECRET_CONFIG or SECRET_CONFIG_FILE)")
+// newVaultToEnvs checks the command line parameters and sets up a VaultToEnvs for loading secrets
+func newVaultToEnvs() *vaulttoenvs.VaultToEnvs {
+	setLogLevel()
+
+	v2eConfig := newConfig()
+
+	if v2eConfig.VaultAddr == "" {
+		log.Fatal("--vault-address must be provided (or env var VAULT_ADDR)")
 	}
 
-	if v2eConfig.SecretConfig != "" && v2eConfig.SecretConfigFile != "" {
-		log.Fatal("Only one of --secret-config OR --secret-config-file can be set")
+	authMethod, err := getAuthMethod()
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	checkSecretConfig(v2eConfig)
+
 	log.Debugf("Vault Address: %s", v2eConfig.VaultAddr)
+	log.Debugf("Auth Method: %s", config.GetString("auth-method"))
+	log.Debugf("Vault Namespace: %s", v2eConfig.Namespace)
+	log.Debugf("Vault CA Cert: %s", v2eConfig.CACert)
+	log.Debugf("Vault CA Path: %s", v2eConfig.CAPath)
+	log.Debugf("Vault Client Cert: %s", v2eConfig.ClientCert)
+	log.Debugf("Vault TLS Server Name: %s", v2eConfig.TLSServerName)
+	log.Debugf("Vault Skip Verify: %v", v2eConfig.TLSSkipVerify)
+	log.Debugf("Output Format: %s", v2eConfig.Format)
+	log.Debugf("Output Metadata: %v", v2eConfig.Metadata)
+	log.Debugf("Sort: %v", v2eConfig.Sort)
 	log.Debugf("Debug: %v", v2eConfig.Debug)
 	log.Debugf("Secret Config: %s", v2eConfig.SecretConfig)
 	log.Debugf("Secret Config File: %s", v2eConfig.SecretConfigFile)
 
 	v2e := vaulttoenvs.NewVaultToEnvs(v2eConfig)
 	v2e.SetLogger(log)
-	v2e.SetVaultToken(config.GetString("vault-token"))
+	if authMethod != nil {
+		v2e.SetAuthMethod(authMethod)
+	} else {
+		v2e.SetVaultToken(findToken())
+	}
 
-	err := v2e.DisplayEnvExports()
+	return v2e
+}
+
+// runValidate checks the secret config without contacting Vault
+func runValidate() {
+	setLogLevel()
+
+	v2eConfig := newConfig()
+	checkSecretConfig(v2eConfig)
+
+	v2e := vaulttoenvs.NewVaultToEnvs(v2eConfig)
+	v2e.SetLogger(log)
+
+	err := v2e.ValidateConfig()
 	if err != nil {
-		log.Fatal(err)
+		fatalError(err)
+	}
+
+	log.Info("Secret config is valid")
+}
+
+func setLogLevel() {
+	if config.GetBool("debug") == true {
+		log.SetLevel(logrus.DebugLevel)
+		log.Debug("Debug level set")
+	} else {
+		log.SetLevel(logrus.InfoLevel)
 	}
 }
+
+// newConfig builds the vaulttoenvs config from the command line parameters
+func newConfig() *vaulttoenvs.Config {
+	return &vaulttoenvs.Config{
+		VaultAddr:        config.GetString("vault-address"),
+		Debug:            config.GetBool("debug"),
+		SecretConfig:     config.GetString("secret-config"),
+		SecretConfigFile: config.GetString("secret-config-file"),
+		CACert:           config.GetString("vault-cacert"),
+		CAPath:           config.GetString("vault-capath"),
+		ClientCert:       config.GetString("vault-client-cert"),
+		ClientKey:        config.GetString("vault-client-key"),
+		TLSServerName:    config.GetString("vault-tls-server-name"),
+		TLSSkipVerify:    config.GetBool("vault-skip-verify"),
+		Namespace:        config.GetString("vault-namespace"),
+		Sort:             config.GetBool("sort"),
+		Format:           config.GetString("format"),
+		Metadata:         config.GetBool("metadata"),
+		MaxTTLPolicy:     config.GetString("max-ttl-policy"),
+		RevokeOnExit:     config.GetBool("revoke-on-exit"),
+	}
+}
+
+// checkSecretConfig ensures exactly one of the secret config string or file is set
+func checkSecretConfig(v2eConfig *vaulttoenvs.Config) {
+	if v2eConfig.SecretConfig == "" && v2eConfig.SecretConfigFile == "" {
+		log.Fatal("--secret-config or --secret-config-file must be provided (or env var SECRET_CONFIG or SECRET_CONFIG_FILE)")
+	}
+
+	if v2eConfig.SecretConfig != "" && v2eConfig.SecretConfigFile != "" {
+		log.Fatal("Only one of --secret-config OR --secret-config-file can be set")
+	}
+}
+
+// fatalError logs the error and exits.  Each secret config problem is logged separately,
+// as the log formatter doesn't print multi-line messages readably
+func fatalError(err error) {
+	if verr, ok := err.(*vaulttoenvs.ValidationError); ok {
+		for _, problem := range verr.Problems {
+			log.Error(problem)
+		}
+		log.Fatal("Invalid secret config")
+	}
+
+	log.Fatal(err)
+}
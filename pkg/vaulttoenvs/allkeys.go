@@ -0,0 +1,227 @@
+package vaulttoenvs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Case transforms applied to secret keys by all keys items, see SecretItem.Case
+const (
+	CaseUpper = "upper" // dbHost becomes DB_HOST (the default)
+	CaseLower = "lower" // dbHost becomes db_host
+	CaseNone  = "none"  // keys are used as they are
+)
+
+// mapSecretValues sets the env var values of the secret item from the data of its secret
+// Env vars in set are mapped first, then with all keys set every other (included) key is mapped
+// to an env var named from the key, so set can be used to rename individual keys
+func mapSecretValues(secretItem *SecretItem, data map[string]interface{}) error {
+
+	for envName, secretKeyName := range secretItem.SecretMaps {
+		if data[secretKeyName] == nil {
+			return fmt.Errorf("Key %s not found in secret %s", secretKeyName, secretItem.SecretPath)
+		}
+		value, ok := data[secretKeyName].(string)
+		if !ok {
+			return fmt.Errorf("Key %s of secret %s is not a string", secretKeyName, secretItem.SecretPath)
+		}
+		secretItem.secretMapValues[envName] = value
+		secretItem.secretMapKeys[envName] = secretKeyName
+	}
+
+	if !secretItem.AllKeys {
+		return nil
+	}
+
+	return mapKeys(secretItem, data, "")
+}
+
+// mapKeys maps every included key of the secret data not already in set to an env var, named
+// from the path of the key relative to the item: for recursive items, the path of the leaf secret
+// (relative to vault_path) followed by the key, otherwise just the key
+func mapKeys(secretItem *SecretItem, data map[string]interface{}, leaf string) error {
+
+	mappedKeys := make(map[string]bool)
+	for _, secretKeyName := range secretItem.SecretMaps {
+		mappedKeys[secretKeyName] = true
+	}
+
+	secretPath := path.Join(secretItem.SecretPath, leaf)
+	for _, secretKeyName := range sortedKeys(data) {
+		keyPath := secretKeyName
+		envName := keyEnvName(secretItem.Prefix, secretKeyName, secretItem.Case)
+		if leaf != "" {
+			keyPath = leaf + "/" + secretKeyName
+			envName = leafEnvName(secretItem.Prefix, leaf, secretItem.Case) + "_" + keyEnvName("", secretKeyName, secretItem.Case)
+		}
+
+		// Keys without a value, such as the security_token of AWS credentials for an IAM user, are skipped
+		if mappedKeys[keyPath] || !includeKey(secretItem, keyPath) || data[secretKeyName] == nil {
+			continue
+		}
+
+		if !envVarNamePattern.MatchString(envName) {
+			return fmt.Errorf("Key %s of secret %s gives invalid env var name '%s'", secretKeyName, secretPath, envName)
+		}
+		if _, ok := secretItem.secretMapValues[envName]; ok {
+			return fmt.Errorf("Key %s of secret %s gives env var %s, which is already set by key %s", secretKeyName, secretPath, envName, secretItem.secretMapKeys[envName])
+		}
+
+		value, ok := keyValue(data[secretKeyName])
+		if !ok {
+			return fmt.Errorf("Key %s of secret %s is not a string, number or boolean (exclude it to skip it)", secretKeyName, secretPath)
+		}
+		secretItem.secretMapValues[envName] = value
+		secretItem.secretMapKeys[envName] = keyPath
+		if leaf != "" {
+			secretItem.secretMapPaths[envName] = secretPath
+		}
+	}
+
+	return nil
+}
+
+// keyValue returns the value of a key as an env var value, converting numbers and booleans to strings
+// Returns false for lists and maps, which have no single value
+func keyValue(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+// includeKey returns true if the key matches an include pattern (or there are none), and
+// doesn't match any exclude pattern
+func includeKey(secretItem *SecretItem, key string) bool {
+	included := len(secretItem.Include) == 0
+	for _, pattern := range secretItem.Include {
+		if matched, _ := path.Match(pattern, key); matched {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, pattern := range secretItem.Exclude {
+		if matched, _ := path.Match(pattern, key); matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// keyEnvName returns the env var name for a secret key, with the case transform applied
+// For the upper and lower cases, camelCase words are separated by underscores, so with the
+// prefix APP_ the key dbHost becomes APP_DB_HOST
+func keyEnvName(prefix string, key string, nameCase string) string {
+	switch nameCase {
+	case CaseNone:
+		return prefix + key
+	case CaseLower:
+		return prefix + strings.ToLower(splitCamelCase(key))
+	}
+	return prefix + strings.ToUpper(splitCamelCase(key))
+}
+
+// leafEnvName returns the start of the env var names for the keys of a leaf secret of a recursive
+// item, from its path relative to the item, so the leaf database/primary gives DATABASE_PRIMARY
+func leafEnvName(prefix string, leaf string, nameCase string) string {
+	var parts []string
+	for _, part := range strings.Split(leaf, "/") {
+		parts = append(parts, keyEnvName("", part, nameCase))
+	}
+	return prefix + strings.Join(parts, "_")
+}
+
+// splitCamelCase inserts an underscore before each word of a camelCase key, treating a run of
+// capitals as one word (e.g. apiURLPath becomes api_URL_Path)
+func splitCamelCase(key string) string {
+	runes := []rune(key)
+	var name strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(r)
+	}
+	return name.String()
+}
+
+// validateAllKeys checks the all keys options of a secret item, which recursive items also use
+func validateAllKeys(label string, secretItem *SecretItem) []string {
+
+	var problems []string
+
+	if !secretItem.AllKeys && !secretItem.Recursive {
+		fields := []struct {
+			name string
+			set  bool
+		}{
+			{"prefix", secretItem.Prefix != ""},
+			{"case", secretItem.Case != ""},
+			{"include", len(secretItem.Include) > 0},
+			{"exclude", len(secretItem.Exclude) > 0},
+		}
+		for _, field := range fields {
+			if field.set {
+				problems = append(problems, fmt.Sprintf("%s: %s can only be used with %s or recursive", label, field.name, fieldName(secretItem, "AllKeys")))
+			}
+		}
+		return problems
+	}
+
+	if secretItem.Prefix != "" && !envVarNamePattern.MatchString(secretItem.Prefix) {
+		problems = append(problems, fmt.Sprintf("%s: invalid prefix '%s'", label, secretItem.Prefix))
+	}
+
+	switch secretItem.Case {
+	case "", CaseUpper, CaseLower, CaseNone:
+	default:
+		problems = append(problems, fmt.Sprintf("%s: unknown case '%s' (must be %s, %s or %s)", label, secretItem.Case, CaseUpper, CaseLower, CaseNone))
+	}
+
+	for _, pattern := range append(append([]string{}, secretItem.Include...), secretItem.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid key pattern '%s'", label, pattern))
+		}
+	}
+
+	return problems
+}
+
+// checkEnvCollisions returns a problem for each env var set by more than one item without
+// override.  Env var names of all keys items are only known once their secrets have been read,
+// so this is checked again after reading them
+func checkEnvCollisions(secretItems []*SecretItem) []string {
+
+	var problems []string
+	envItems := make(map[string]int)
+
+	for i, secretItem := range secretItems {
+		for _, envName := range sortedKeys(secretItem.secretMapValues) {
+			if previous, ok := envItems[envName]; ok && !secretItem.Override {
+				problems = append(problems, fmt.Sprintf("%s: env var %s is already set by %s (set override to replace it)", itemLabel(i, secretItem), envName, itemLabel(previous, secretItems[previous])))
+			} else {
+				envItems[envName] = i
+			}
+		}
+	}
+
+	return problems
+}
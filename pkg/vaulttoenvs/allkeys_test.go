@@ -0,0 +1,311 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyEnvName(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		key      string
+		nameCase string
+		expected string
+	}{
+		{prefix: "APP_", key: "dbHost", expected: "APP_DB_HOST"},
+		{key: "db_host", expected: "DB_HOST"},
+		{key: "apiURLPath", nameCase: CaseUpper, expected: "API_URL_PATH"},
+		{key: "oauth2Secret", expected: "OAUTH2_SECRET"},
+		{key: "PASSWORD", expected: "PASSWORD"},
+		{prefix: "app_", key: "dbHost", nameCase: CaseLower, expected: "app_db_host"},
+		{prefix: "APP_", key: "dbHost", nameCase: CaseNone, expected: "APP_dbHost"},
+		{key: "db-host", expected: "DB-HOST"},
+	}
+
+	for _, test := range tests {
+		if result := keyEnvName(test.prefix, test.key, test.nameCase); result != test.expected {
+			t.Errorf("Expected %s for key %s, got %s", test.expected, test.key, result)
+		}
+	}
+}
+
+func TestAllKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     *SecretItem
+		expected []string
+	}{
+		{
+			name:     "all keys",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true},
+			expected: []string{"API_TOKEN=abc", "DB_HOST=db", "DB_PASSWORD=hunter2", "DEBUG=true"},
+		},
+		{
+			name:     "prefix",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true, Prefix: "APP_"},
+			expected: []string{"APP_API_TOKEN=abc", "APP_DB_HOST=db", "APP_DB_PASSWORD=hunter2", "APP_DEBUG=true"},
+		},
+		{
+			name:     "case none",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true, Case: CaseNone},
+			expected: []string{"apiToken=abc", "dbHost=db", "dbPassword=hunter2", "debug=true"},
+		},
+		{
+			name:     "include",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true, Include: []string{"db*", "api*"}},
+			expected: []string{"API_TOKEN=abc", "DB_HOST=db", "DB_PASSWORD=hunter2"},
+		},
+		{
+			name:     "include and exclude",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true, Include: []string{"db*"}, Exclude: []string{"*Password"}},
+			expected: []string{"DB_HOST=db"},
+		},
+		{
+			name:     "set renames keys",
+			item:     &SecretItem{SecretPath: "secret/app", AllKeys: true, Exclude: []string{"debug"}, SecretMaps: map[string]string{"DATABASE_HOST": "dbHost"}},
+			expected: []string{"API_TOKEN=abc", "DATABASE_HOST=db", "DB_PASSWORD=hunter2"},
+		},
+		{
+			name:     "kv version 2",
+			item:     &SecretItem{SecretPath: "kv/app", AllKeys: true},
+			expected: []string{"DB_HOST=kv-db", "DB_PASSWORD=kv-hunter2"},
+		},
+		{
+			name:     "null values skipped",
+			item:     &SecretItem{SecretPath: "secret/creds", AllKeys: true},
+			expected: []string{"ACCESS_KEY=AKID", "SECRET_KEY=secret"},
+		},
+		{
+			name:     "numbers and booleans",
+			item:     &SecretItem{SecretPath: "kv/db", AllKeys: true},
+			expected: []string{"HOST=db", "PORT=5432", "REPLICA_LAG=0.5", "SSL=true"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"secret/": kv1Mount, "kv/": kv2Mount})
+			fv.genericSecret("secret/app", map[string]interface{}{"dbHost": "db", "dbPassword": "hunter2", "apiToken": "abc", "debug": "true"})
+			fv.kv2Secret("kv/app", 1, map[string]interface{}{"dbHost": "kv-db", "dbPassword": "kv-hunter2"})
+			fv.genericSecret("secret/creds", map[string]interface{}{"access_key": "AKID", "secret_key": "secret", "security_token": nil})
+			fv.kv2Secret("kv/db", 1, map[string]interface{}{"host": "db", "port": 5432, "replicaLag": 0.5, "ssl": true})
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(test.item)
+
+			envs, err := v2e.GetEnvs()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(envs, test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, envs)
+			}
+		})
+	}
+}
+
+// The metadata of all keys env vars includes the key each was read from
+func TestAllKeysMetadata(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+	fv.kv2Secret("kv/app", 1, map[string]interface{}{"dbHost": "kv-db", "dbPassword": "kv-hunter2"})
+
+	var out bytes.Buffer
+	v2e := NewVaultToEnvs(&Config{
+		VaultAddr:    fv.URL,
+		SecretConfig: `[{"vault_path": "kv/app", "all_keys": true, "include": ["dbHost"]}]`,
+		Format:       FormatJSON,
+		Metadata:     true,
+	})
+	v2e.SetVaultToken("token")
+	v2e.SetOutput(&out)
+
+	if err := v2e.DisplayEnvExports(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var metadata []envVarMetadata
+	if err := json.Unmarshal(out.Bytes(), &metadata); err != nil {
+		t.Fatalf("Error parsing output %q: %v", out.String(), err)
+	}
+	if len(metadata) != 1 || metadata[0].Name != "DB_HOST" || metadata[0].Key != "dbHost" {
+		t.Errorf("Expected DB_HOST to be read from key dbHost, got %+v", metadata)
+	}
+}
+
+func TestAllKeysErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  map[string]interface{}
+		item  *SecretItem
+		error string
+	}{
+		{
+			name:  "invalid env var name",
+			data:  map[string]interface{}{"db-host": "db"},
+			item:  &SecretItem{SecretPath: "secret/app", AllKeys: true},
+			error: "Key db-host of secret secret/app gives invalid env var name 'DB-HOST'",
+		},
+		{
+			name:  "leading digit",
+			data:  map[string]interface{}{"2fa": "code"},
+			item:  &SecretItem{SecretPath: "secret/app", AllKeys: true},
+			error: "Key 2fa of secret secret/app gives invalid env var name '2FA'",
+		},
+		{
+			name:  "keys giving the same name",
+			data:  map[string]interface{}{"dbHost": "a", "db_host": "b"},
+			item:  &SecretItem{SecretPath: "secret/app", AllKeys: true},
+			error: "Key db_host of secret secret/app gives env var DB_HOST, which is already set by key dbHost",
+		},
+		{
+			name:  "list value",
+			data:  map[string]interface{}{"hosts": []string{"db1", "db2"}},
+			item:  &SecretItem{SecretPath: "secret/app", AllKeys: true},
+			error: "Key hosts of secret secret/app is not a string, number or boolean (exclude it to skip it)",
+		},
+		{
+			name:  "set key not a string",
+			data:  map[string]interface{}{"port": 5432},
+			item:  &SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_PORT": "port"}},
+			error: "Key port of secret secret/app is not a string",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"secret/": kv1Mount})
+			fv.genericSecret("secret/app", test.data)
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(test.item)
+
+			if _, err := v2e.GetEnvs(); err == nil || err.Error() != test.error {
+				t.Errorf("Expected error %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+// Env vars of all keys items can only be checked for collisions once the secrets have been read
+func TestAllKeysCollisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    []*SecretItem
+		expected []string
+		problem  string
+	}{
+		{
+			name:    "collides with earlier item",
+			items:   []*SecretItem{{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_HOST": "dbHost"}}, {SecretPath: "kv/app", AllKeys: true}},
+			problem: "item 2 (kv/app): env var DB_HOST is already set by item 1 (secret/app) (set override to replace it)",
+		},
+		{
+			name:     "override",
+			items:    []*SecretItem{{SecretPath: "secret/app", AllKeys: true, Include: []string{"db*"}}, {SecretPath: "kv/app", AllKeys: true, Override: true, Include: []string{"dbHost"}}},
+			expected: []string{"DB_PASSWORD=hunter2", "DB_HOST=kv-db"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"secret/": kv1Mount, "kv/": kv2Mount})
+			fv.genericSecret("secret/app", map[string]interface{}{"dbHost": "db", "dbPassword": "hunter2", "apiToken": "abc", "debug": "true"})
+			fv.kv2Secret("kv/app", 1, map[string]interface{}{"dbHost": "kv-db", "dbPassword": "kv-hunter2"})
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(test.items...)
+
+			envs, err := v2e.GetEnvs()
+			if test.problem != "" {
+				verr, ok := err.(*ValidationError)
+				if !ok || len(verr.Problems) != 1 || verr.Problems[0] != test.problem {
+					t.Fatalf("Expected problem %q, got %v", test.problem, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(envs, test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, envs)
+			}
+		})
+	}
+}
+
+func TestValidateAllKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		problems []string
+	}{
+		{
+			name:   "valid",
+			config: `[{"vault_path": "secret/app", "all_keys": true, "prefix": "APP_", "case": "lower", "include": ["db*"], "exclude": ["*[Pp]assword"]}]`,
+		},
+		{
+			name:     "options without all keys",
+			config:   `[{"vault_path": "secret/app", "prefix": "APP_", "exclude": ["debug"], "set": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 1 (secret/app): prefix can only be used with all_keys or recursive", "item 1 (secret/app): exclude can only be used with all_keys or recursive"},
+		},
+		{
+			name:     "invalid options",
+			config:   `[{"vault_path": "secret/app", "all_keys": true, "prefix": "1APP", "case": "camel", "include": ["db[*"]}]`,
+			problems: []string{"item 1 (secret/app): invalid prefix '1APP'", "item 1 (secret/app): unknown case 'camel' (must be upper, lower or none)", "item 1 (secret/app): invalid key pattern 'db[*'"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewVaultToEnvs(&Config{SecretConfig: test.config}).ValidateConfig()
+			if test.problems == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Expected a validation error, got %v", err)
+			}
+			if strings.Join(verr.Problems, "\n") != strings.Join(test.problems, "\n") {
+				t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(test.problems, "\n"), strings.Join(verr.Problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestAllKeysYAML(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": kv1Mount})
+	fv.genericSecret("secret/app", map[string]interface{}{"dbHost": "db", "dbPassword": "hunter2", "apiToken": "abc"})
+
+	config := "- secretPath: secret/app\n  allKeys: true\n  prefix: APP_\n  include:\n    - db*\n"
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, SecretConfigFile: writeTempFile(t, "secrets.yaml", config)})
+	v2e.SetVaultToken("token")
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"APP_DB_HOST=db", "APP_DB_PASSWORD=hunter2"}
+	if !reflect.DeepEqual(envs, expected) {
+		t.Errorf("Expected %q, got %q", expected, envs)
+	}
+}
@@ -0,0 +1,324 @@
+package vaulttoenvs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// DefaultKubernetesJWTPath is the default location of the pod's service account token
+const DefaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// AuthMethod is an interface for Vault authentication methods.  Login is called with
+// an unauthenticated Vault client and returns the token to use for reading secrets
+type AuthMethod interface {
+	Login(client *VaultApi.Client) (string, error)
+}
+
+// AppRoleAuth logs into Vault using the AppRole auth method
+type AppRoleAuth struct {
+	RoleID                string
+	RoleIDFile            string
+	SecretID              string
+	SecretIDFile          string
+	SecretIDWrappingToken string // response-wrapped secret_id, unwrapped at login
+	MountPath             string // defaults to "approle"
+}
+
+// Login authenticates with the role_id/secret_id and returns the client token
+func (a *AppRoleAuth) Login(client *VaultApi.Client) (string, error) {
+
+	roleID, err := valueOrFile(a.RoleID, a.RoleIDFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading AppRole role_id: %v", err)
+	}
+	if roleID == "" {
+		return "", fmt.Errorf("AppRole role_id not provided")
+	}
+
+	secretID, err := valueOrFile(a.SecretID, a.SecretIDFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading AppRole secret_id: %v", err)
+	}
+
+	if a.SecretIDWrappingToken != "" {
+		if secretID != "" {
+			return "", fmt.Errorf("Only one of AppRole secret_id OR wrapped secret_id can be set")
+		}
+
+		secret, err := unwrap(client, a.SecretIDWrappingToken)
+		if err != nil {
+			return "", err
+		}
+
+		secretID, _ = secret.Data["secret_id"].(string)
+		if secretID == "" {
+			return "", fmt.Errorf("Wrapped response does not contain an AppRole secret_id")
+		}
+	}
+
+	data := map[string]interface{}{
+		"role_id": roleID,
+	}
+
+	// secret_id is optional if the role has bind_secret_id disabled
+	if secretID != "" {
+		data["secret_id"] = secretID
+	}
+
+	return login(client, authMountPath(a.MountPath, "approle"), data)
+}
+
+// KubernetesAuth logs into Vault using the Kubernetes auth method and the pod's service account token
+type KubernetesAuth struct {
+	Role      string
+	JWTPath   string // defaults to DefaultKubernetesJWTPath
+	MountPath string // defaults to "kubernetes"
+}
+
+// Login authenticates with the service account JWT and returns the client token
+func (k *KubernetesAuth) Login(client *VaultApi.Client) (string, error) {
+
+	if k.Role == "" {
+		return "", fmt.Errorf("Kubernetes auth role not provided")
+	}
+
+	jwtPath := k.JWTPath
+	if jwtPath == "" {
+		jwtPath = DefaultKubernetesJWTPath
+	}
+
+	jwt, err := valueOrFile("", jwtPath)
+	if err != nil {
+		return "", fmt.Errorf("Error reading Kubernetes service account token: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"role": k.Role,
+		"jwt":  jwt,
+	}
+
+	return login(client, authMountPath(k.MountPath, "kubernetes"), data)
+}
+
+// JWTAuth logs into Vault using the JWT/OIDC auth method, e.g. with a CI provider's ID token
+type JWTAuth struct {
+	Role      string
+	JWT       string
+	JWTFile   string
+	MountPath string // defaults to "jwt"
+}
+
+// Login authenticates with the JWT and returns the client token
+func (j *JWTAuth) Login(client *VaultApi.Client) (string, error) {
+
+	jwt, err := valueOrFile(j.JWT, j.JWTFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading JWT: %v", err)
+	}
+	if jwt == "" {
+		return "", fmt.Errorf("JWT not provided")
+	}
+
+	data := map[string]interface{}{
+		"jwt": jwt,
+	}
+
+	// role is optional if the auth method has a default role
+	if j.Role != "" {
+		data["role"] = j.Role
+	}
+
+	return login(client, authMountPath(j.MountPath, "jwt"), data)
+}
+
+// AWSAuth logs into Vault using the AWS IAM auth method and the ambient AWS credential chain
+type AWSAuth struct {
+	Role        string
+	MountPath   string // defaults to "aws"
+	HeaderValue string // value of the X-Vault-AWS-IAM-Server-ID header, if required by Vault
+	Region      string // region of the STS endpoint, defaults to the global endpoint (us-east-1)
+}
+
+// Login signs an sts:GetCallerIdentity request and returns the client token
+func (a *AWSAuth) Login(client *VaultApi.Client) (string, error) {
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating AWS session: %s", err.Error())
+	}
+
+	// Use the regional STS endpoint if a region is given, otherwise the global one
+	stsConfig := &aws.Config{Region: aws.String("us-east-1")}
+	if a.Region != "" {
+		stsConfig.Region = aws.String(a.Region)
+		stsConfig.Endpoint = aws.String(fmt.Sprintf("https://sts.%s.amazonaws.com", a.Region))
+	}
+
+	// Build and sign the request, Vault makes the actual call to STS
+	svc := sts.New(sess, stsConfig)
+	stsRequest, _ := svc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	if a.HeaderValue != "" {
+		stsRequest.HTTPRequest.Header.Add("X-Vault-AWS-IAM-Server-ID", a.HeaderValue)
+	}
+	err = stsRequest.Sign()
+	if err != nil {
+		return "", fmt.Errorf("Error signing AWS STS request: %s", err.Error())
+	}
+
+	headers, err := json.Marshal(stsRequest.HTTPRequest.Header)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding AWS STS request headers: %s", err.Error())
+	}
+	body, err := ioutil.ReadAll(stsRequest.HTTPRequest.Body)
+	if err != nil {
+		return "", fmt.Errorf("Error reading AWS STS request body: %s", err.Error())
+	}
+
+	data := map[string]interface{}{
+		"iam_http_request_method": stsRequest.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(stsRequest.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}
+
+	// role defaults to the name of the IAM principal
+	if a.Role != "" {
+		data["role"] = a.Role
+	}
+
+	return login(client, authMountPath(a.MountPath, "aws"), data)
+}
+
+// CertAuth logs into Vault using the TLS certificate auth method.  The client certificate
+// is taken from the ClientCert/ClientKey in the Config
+type CertAuth struct {
+	Name      string // certificate role to log in against, defaults to trying all roles
+	MountPath string // defaults to "cert"
+}
+
+// Login authenticates with the client certificate and returns the client token
+func (c *CertAuth) Login(client *VaultApi.Client) (string, error) {
+
+	data := map[string]interface{}{}
+	if c.Name != "" {
+		data["name"] = c.Name
+	}
+
+	return login(client, authMountPath(c.MountPath, "cert"), data)
+}
+
+// WrappedTokenAuth unwraps a response-wrapped Vault token
+type WrappedTokenAuth struct {
+	WrappingToken string
+}
+
+// Login unwraps the wrapping token and returns the wrapped client token
+func (w *WrappedTokenAuth) Login(client *VaultApi.Client) (string, error) {
+
+	secret, err := unwrap(client, w.WrappingToken)
+	if err != nil {
+		return "", err
+	}
+
+	if secret.Auth != nil && secret.Auth.ClientToken != "" {
+		return secret.Auth.ClientToken, nil
+	}
+
+	// Tokens wrapped outside of a login/token create response
+	if token, ok := secret.Data["token"].(string); ok && token != "" {
+		return token, nil
+	}
+
+	return "", fmt.Errorf("Wrapped response does not contain a token")
+}
+
+// canLogInAgain returns false if the auth method can't log in more than once, as it unwraps a
+// single use wrapping token
+func canLogInAgain(auth AuthMethod) bool {
+	switch a := auth.(type) {
+	case nil:
+		return false
+	case *WrappedTokenAuth:
+		return false
+	case *AppRoleAuth:
+		return a.SecretIDWrappingToken == ""
+	}
+	return true
+}
+
+// unwrap unwraps a response-wrapped secret using the wrapping token
+// The client token is cleared afterwards as the wrapping token can't be used again
+func unwrap(client *VaultApi.Client, wrappingToken string) (*VaultApi.Secret, error) {
+
+	client.ClearToken()
+	secret, err := client.Logical().Unwrap(wrappingToken)
+	client.ClearToken()
+
+	if err != nil {
+		if strings.Contains(err.Error(), "wrapping token is not valid or does not exist") {
+			return nil, fmt.Errorf("Wrapping token is invalid, expired or has already been used. If it was not used by this process, it may have been intercepted: %s", err.Error())
+		}
+		return nil, fmt.Errorf("Error unwrapping response: %s", err.Error())
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("No data returned when unwrapping response")
+	}
+
+	return secret, nil
+}
+
+// login writes the login data to the auth mount and returns the resulting client token
+func login(client *VaultApi.Client, mountPath string, data map[string]interface{}) (string, error) {
+
+	loginPath := "auth/" + mountPath + "/login"
+	secret, err := client.Logical().Write(loginPath, data)
+	if err != nil {
+		return "", fmt.Errorf("Error logging in to %s: %s", loginPath, err.Error())
+	}
+
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("No token returned from login to %s", loginPath)
+	}
+
+	return secret.Auth.ClientToken, nil
+}
+
+// authMountPath returns the trimmed mount path or the default if one isn't set
+func authMountPath(mountPath string, defaultPath string) string {
+	mountPath = strings.Trim(mountPath, "/")
+	if mountPath == "" {
+		return defaultPath
+	}
+	return mountPath
+}
+
+// valueOrFile returns value if set, otherwise the trimmed contents of file (if set)
+// An empty file is an error, as it almost always means the wrong file was given
+func valueOrFile(value string, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	value = strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("File %s is empty", file)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,656 @@
+package vaulttoenvs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAppRoleAuthLogin(t *testing.T) {
+	tests := []struct {
+		name         string
+		auth         AppRoleAuth
+		roleIDFile   string
+		secretIDFile string
+		loginPath    string
+		roleID       string
+		secretID     interface{}
+	}{
+		{
+			name:      "values",
+			auth:      AppRoleAuth{RoleID: "role", SecretID: "secret"},
+			loginPath: "auth/approle/login",
+			roleID:    "role",
+			secretID:  "secret",
+		},
+		{
+			name:         "files",
+			roleIDFile:   " role-from-file\n",
+			secretIDFile: "secret-from-file\n\n",
+			loginPath:    "auth/approle/login",
+			roleID:       "role-from-file",
+			secretID:     "secret-from-file",
+		},
+		{
+			name:       "value takes precedence over file",
+			auth:       AppRoleAuth{RoleID: "role", SecretID: "secret"},
+			roleIDFile: "role-from-file",
+			loginPath:  "auth/approle/login",
+			roleID:     "role",
+			secretID:   "secret",
+		},
+		{
+			name:      "no secret_id",
+			auth:      AppRoleAuth{RoleID: "role"},
+			loginPath: "auth/approle/login",
+			roleID:    "role",
+			secretID:  nil,
+		},
+		{
+			name:      "custom mount",
+			auth:      AppRoleAuth{RoleID: "role", SecretID: "secret", MountPath: "/ci/approle/"},
+			loginPath: "auth/ci/approle/login",
+			roleID:    "role",
+			secretID:  "secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.respond("PUT", test.loginPath, 200, loginResponse("approle-token"))
+
+			auth := test.auth
+			if test.roleIDFile != "" {
+				auth.RoleIDFile = writeTempFile(t, "role_id", test.roleIDFile)
+			}
+			if test.secretIDFile != "" {
+				auth.SecretIDFile = writeTempFile(t, "secret_id", test.secretIDFile)
+			}
+
+			token, err := auth.Login(fv.client())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if token != "approle-token" {
+				t.Errorf("Expected token approle-token, got %s", token)
+			}
+
+			requests := fv.requestsTo("PUT", test.loginPath)
+			if len(requests) != 1 {
+				t.Fatalf("Expected 1 login request to %s, got %d", test.loginPath, len(requests))
+			}
+			if requests[0].Body["role_id"] != test.roleID {
+				t.Errorf("Expected role_id %s, got %v", test.roleID, requests[0].Body["role_id"])
+			}
+			secretID, ok := requests[0].Body["secret_id"]
+			if test.secretID == nil && ok {
+				t.Errorf("Expected secret_id to be omitted, got %v", secretID)
+			} else if test.secretID != nil && secretID != test.secretID {
+				t.Errorf("Expected secret_id %v, got %v", test.secretID, secretID)
+			}
+		})
+	}
+}
+
+func TestAppRoleAuthErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  AppRoleAuth
+		error string
+	}{
+		{
+			name:  "no role_id",
+			auth:  AppRoleAuth{SecretID: "secret"},
+			error: "role_id not provided",
+		},
+		{
+			name:  "missing role_id file",
+			auth:  AppRoleAuth{RoleIDFile: "/does/not/exist"},
+			error: "Error reading AppRole role_id",
+		},
+		{
+			name:  "missing secret_id file",
+			auth:  AppRoleAuth{RoleID: "role", SecretIDFile: "/does/not/exist"},
+			error: "Error reading AppRole secret_id",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			_, err := test.auth.Login(fv.client())
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("Expected error containing '%s', got %v", test.error, err)
+			}
+			if len(fv.requestsTo("PUT", "auth/approle/login")) != 0 {
+				t.Errorf("Expected no login request")
+			}
+		})
+	}
+}
+
+func TestAppRoleAuthEmptySecretIDFile(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	auth := &AppRoleAuth{RoleID: "role", SecretIDFile: writeTempFile(t, "secret_id", "\n")}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("Expected empty file error, got %v", err)
+	}
+}
+
+func TestAppRoleAuthNoTokenReturned(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/approle/login", 200, map[string]interface{}{"data": map[string]interface{}{}})
+
+	auth := &AppRoleAuth{RoleID: "role", SecretID: "secret"}
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "No token returned") {
+		t.Errorf("Expected no token error, got %v", err)
+	}
+}
+
+func TestAuthMethodTokenUsedByLoadSecrets(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/approle/login", 200, loginResponse("approle-token"))
+	fv.mounts(map[string]interface{}{
+		"secret/": map[string]interface{}{"type": "generic"},
+	})
+	fv.respond("GET", "secret/app", 200, map[string]interface{}{
+		"data": map[string]interface{}{"password": "hunter2"},
+	})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("stale-token")
+	v2e.SetAuthMethod(&AppRoleAuth{RoleID: "role", SecretID: "secret"})
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "password"}})
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	logins := fv.requestsTo("PUT", "auth/approle/login")
+	if len(logins) != 1 || logins[0].Token != "" {
+		t.Errorf("Expected a single login without a token, got %v", logins)
+	}
+	for _, req := range append(fv.requestsTo("GET", "sys/mounts"), fv.requestsTo("GET", "secret/app")...) {
+		if req.Token != "approle-token" {
+			t.Errorf("Expected %s to use the login token, got '%s'", req.Path, req.Token)
+		}
+	}
+}
+
+func TestKubernetesAuthLogin(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/k8s/login", 200, loginResponse("k8s-token"))
+
+	jwtPath := writeTempFile(t, "token", "service-account-jwt\n")
+	auth := &KubernetesAuth{Role: "my-role", JWTPath: jwtPath, MountPath: "/k8s/"}
+
+	token, err := auth.Login(fv.client())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "k8s-token" {
+		t.Errorf("Expected token k8s-token, got %s", token)
+	}
+
+	requests := fv.requestsTo("PUT", "auth/k8s/login")
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 login request, got %d", len(requests))
+	}
+	if requests[0].Body["role"] != "my-role" {
+		t.Errorf("Expected role my-role, got %v", requests[0].Body["role"])
+	}
+	if requests[0].Body["jwt"] != "service-account-jwt" {
+		t.Errorf("Expected jwt service-account-jwt, got %v", requests[0].Body["jwt"])
+	}
+}
+
+func TestKubernetesAuthDefaultMount(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/kubernetes/login", 200, loginResponse("k8s-token"))
+
+	auth := &KubernetesAuth{Role: "my-role", JWTPath: writeTempFile(t, "token", "jwt")}
+	if _, err := auth.Login(fv.client()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(fv.requestsTo("PUT", "auth/kubernetes/login")) != 1 {
+		t.Errorf("Expected login against the default kubernetes mount")
+	}
+}
+
+func TestKubernetesAuthDefaultJWTPath(t *testing.T) {
+	if _, err := os.Stat(DefaultKubernetesJWTPath); err == nil {
+		t.Skip("Running with a service account token mounted")
+	}
+
+	fv := newFakeVault(t)
+	defer fv.Close()
+	auth := &KubernetesAuth{Role: "my-role"}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), DefaultKubernetesJWTPath) {
+		t.Errorf("Expected error reading %s, got %v", DefaultKubernetesJWTPath, err)
+	}
+	if len(fv.requestsTo("PUT", "auth/kubernetes/login")) != 0 {
+		t.Errorf("Expected no login request without a token")
+	}
+}
+
+func TestKubernetesAuthEmptyJWT(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	auth := &KubernetesAuth{Role: "my-role", JWTPath: writeTempFile(t, "token", "  \n")}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("Expected empty token error, got %v", err)
+	}
+	if len(fv.requestsTo("PUT", "auth/kubernetes/login")) != 0 {
+		t.Errorf("Expected no login request with an empty token")
+	}
+}
+
+func TestKubernetesAuthLoadSecrets(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/kubernetes/login", 200, loginResponse("k8s-token"))
+	fv.mounts(map[string]interface{}{
+		"secret/": map[string]interface{}{"type": "generic"},
+	})
+	fv.respond("GET", "secret/app", 200, map[string]interface{}{
+		"data": map[string]interface{}{"password": "hunter2"},
+	})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetAuthMethod(&KubernetesAuth{Role: "my-role", JWTPath: writeTempFile(t, "token", "jwt")})
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "password"}})
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(envs) != 1 || envs[0] != "PASSWORD=hunter2" {
+		t.Errorf("Unexpected envs: %v", envs)
+	}
+
+	reads := fv.requestsTo("GET", "secret/app")
+	if len(reads) != 1 || reads[0].Token != "k8s-token" {
+		t.Errorf("Expected secret to be read with the login token, got %v", reads)
+	}
+}
+
+func TestJWTAuthLogin(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      JWTAuth
+		jwtFile   string
+		loginPath string
+		jwt       string
+		role      interface{}
+	}{
+		{
+			name:      "value",
+			auth:      JWTAuth{JWT: "id-token", Role: "ci"},
+			loginPath: "auth/jwt/login",
+			jwt:       "id-token",
+			role:      "ci",
+		},
+		{
+			name:      "file",
+			auth:      JWTAuth{Role: "ci"},
+			jwtFile:   "id-token-from-file\n",
+			loginPath: "auth/jwt/login",
+			jwt:       "id-token-from-file",
+			role:      "ci",
+		},
+		{
+			name:      "default role",
+			auth:      JWTAuth{JWT: "id-token"},
+			loginPath: "auth/jwt/login",
+			jwt:       "id-token",
+			role:      nil,
+		},
+		{
+			name:      "custom mount",
+			auth:      JWTAuth{JWT: "id-token", Role: "ci", MountPath: "gitlab/"},
+			loginPath: "auth/gitlab/login",
+			jwt:       "id-token",
+			role:      "ci",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.respond("PUT", test.loginPath, 200, loginResponse("jwt-token"))
+
+			auth := test.auth
+			if test.jwtFile != "" {
+				auth.JWTFile = writeTempFile(t, "jwt", test.jwtFile)
+			}
+
+			token, err := auth.Login(fv.client())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if token != "jwt-token" {
+				t.Errorf("Expected token jwt-token, got %s", token)
+			}
+
+			requests := fv.requestsTo("PUT", test.loginPath)
+			if len(requests) != 1 {
+				t.Fatalf("Expected 1 login request to %s, got %d", test.loginPath, len(requests))
+			}
+			if requests[0].Body["jwt"] != test.jwt {
+				t.Errorf("Expected jwt %s, got %v", test.jwt, requests[0].Body["jwt"])
+			}
+			role, ok := requests[0].Body["role"]
+			if test.role == nil && ok {
+				t.Errorf("Expected role to be omitted, got %v", role)
+			} else if test.role != nil && role != test.role {
+				t.Errorf("Expected role %v, got %v", test.role, role)
+			}
+		})
+	}
+}
+
+func TestJWTAuthNoJWT(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	auth := &JWTAuth{Role: "ci"}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "JWT not provided") {
+		t.Errorf("Expected missing JWT error, got %v", err)
+	}
+}
+
+func TestJWTAuthLoginDenied(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/jwt/login", 400, map[string]interface{}{"errors": []string{"token is expired"}})
+
+	auth := &JWTAuth{JWT: "expired", Role: "ci"}
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "token is expired") {
+		t.Errorf("Expected login error from Vault, got %v", err)
+	}
+}
+
+// setAWSTestCredentials points the AWS credential chain at static test credentials
+func setAWSTestCredentials(env testEnv) {
+	env.set("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	env.set("AWS_SECRET_ACCESS_KEY", "secret")
+	env.set("AWS_SESSION_TOKEN", "")
+	env.set("AWS_REGION", "ap-southeast-2")
+	env.set("AWS_PROFILE", "")
+	env.set("AWS_CONFIG_FILE", "/does/not/exist")
+	env.set("AWS_SHARED_CREDENTIALS_FILE", "/does/not/exist")
+}
+
+// decodeBase64 decodes a base64 encoded login field
+func decodeBase64(t *testing.T, field interface{}) string {
+	value, err := base64.StdEncoding.DecodeString(field.(string))
+	if err != nil {
+		t.Fatalf("Error decoding %v: %v", field, err)
+	}
+	return string(value)
+}
+
+func TestAWSAuthLogin(t *testing.T) {
+	env := testEnv{}
+	defer env.restore()
+	setAWSTestCredentials(env)
+
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/aws-iam/login", 200, loginResponse("aws-token"))
+
+	auth := &AWSAuth{Role: "my-role", MountPath: "aws-iam", HeaderValue: "vault.example.com"}
+	token, err := auth.Login(fv.client())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "aws-token" {
+		t.Errorf("Expected token aws-token, got %s", token)
+	}
+
+	requests := fv.requestsTo("PUT", "auth/aws-iam/login")
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 login request, got %d", len(requests))
+	}
+	body := requests[0].Body
+
+	if body["role"] != "my-role" {
+		t.Errorf("Expected role my-role, got %v", body["role"])
+	}
+	if body["iam_http_request_method"] != "POST" {
+		t.Errorf("Expected method POST, got %v", body["iam_http_request_method"])
+	}
+	if url := decodeBase64(t, body["iam_request_url"]); url != "https://sts.amazonaws.com/" {
+		t.Errorf("Expected global STS url, got %s", url)
+	}
+	if reqBody := decodeBase64(t, body["iam_request_body"]); !strings.Contains(reqBody, "Action=GetCallerIdentity") {
+		t.Errorf("Expected GetCallerIdentity request body, got %s", reqBody)
+	}
+
+	var headers http.Header
+	if err := json.Unmarshal([]byte(decodeBase64(t, body["iam_request_headers"])), &headers); err != nil {
+		t.Fatalf("Error decoding headers: %v", err)
+	}
+	if headers.Get("X-Vault-AWS-IAM-Server-ID") != "vault.example.com" {
+		t.Errorf("Expected server ID header, got %v", headers)
+	}
+	if !strings.Contains(headers.Get("Authorization"), "Credential=AKIDTEST/") {
+		t.Errorf("Expected request signed with test credentials, got %s", headers.Get("Authorization"))
+	}
+	if !strings.Contains(headers.Get("Authorization"), "x-vault-aws-iam-server-id") {
+		t.Errorf("Expected server ID header to be signed, got %s", headers.Get("Authorization"))
+	}
+}
+
+func TestAWSAuthDefaults(t *testing.T) {
+	env := testEnv{}
+	defer env.restore()
+	setAWSTestCredentials(env)
+
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/aws/login", 200, loginResponse("aws-token"))
+
+	auth := &AWSAuth{Region: "eu-west-1"}
+	if _, err := auth.Login(fv.client()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	requests := fv.requestsTo("PUT", "auth/aws/login")
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 login request to the default mount, got %d", len(requests))
+	}
+	if _, ok := requests[0].Body["role"]; ok {
+		t.Errorf("Expected role to be omitted")
+	}
+
+	var headers http.Header
+	json.Unmarshal([]byte(decodeBase64(t, requests[0].Body["iam_request_headers"])), &headers)
+	if headers.Get("X-Vault-AWS-IAM-Server-ID") != "" {
+		t.Errorf("Expected no server ID header")
+	}
+	if !strings.Contains(headers.Get("Authorization"), "/eu-west-1/sts/") {
+		t.Errorf("Expected request signed for eu-west-1, got %s", headers.Get("Authorization"))
+	}
+	if url := decodeBase64(t, requests[0].Body["iam_request_url"]); url != "https://sts.eu-west-1.amazonaws.com/" {
+		t.Errorf("Expected regional STS url, got %s", url)
+	}
+}
+
+func TestCertAuthLoadSecrets(t *testing.T) {
+	fv := genericSecretVault(newFakeVaultTLS(t))
+	defer fv.Close()
+	fv.respond("PUT", "auth/tls/login", 200, loginResponse("cert-token"))
+	certFile, keyFile := writeClientCert(t, "my-client")
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, CACert: fv.caCertFile(), ClientCert: certFile, ClientKey: keyFile})
+	v2e.SetAuthMethod(&CertAuth{Name: "web", MountPath: "tls"})
+	v2e.AddSecretItems(passwordItem())
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	logins := fv.requestsTo("PUT", "auth/tls/login")
+	if len(logins) != 1 {
+		t.Fatalf("Expected 1 login request, got %d", len(logins))
+	}
+	if logins[0].CertCN != "my-client" {
+		t.Errorf("Expected login with the client certificate, got '%s'", logins[0].CertCN)
+	}
+	if logins[0].Body["name"] != "web" {
+		t.Errorf("Expected name web, got %v", logins[0].Body["name"])
+	}
+
+	reads := fv.requestsTo("GET", "secret/app")
+	if len(reads) != 1 || reads[0].Token != "cert-token" {
+		t.Errorf("Expected secret to be read with the login token, got %v", reads)
+	}
+}
+
+func TestCertAuthDefaults(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/cert/login", 200, loginResponse("cert-token"))
+
+	auth := &CertAuth{}
+	if _, err := auth.Login(fv.client()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	logins := fv.requestsTo("PUT", "auth/cert/login")
+	if len(logins) != 1 {
+		t.Fatalf("Expected 1 login request to the default mount, got %d", len(logins))
+	}
+	if _, ok := logins[0].Body["name"]; ok {
+		t.Errorf("Expected name to be omitted")
+	}
+}
+
+// wrappingVault returns a fake Vault that unwraps the wrapping token "wrapping-token" once
+func wrappingVault(t *testing.T, response map[string]interface{}) *fakeVault {
+	fv := newFakeVault(t)
+	fv.wrappedResponse(response)
+	return fv
+}
+
+func TestWrappedTokenAuth(t *testing.T) {
+	fv := wrappingVault(t, loginResponse("unwrapped-token"))
+	defer fv.Close()
+	genericSecretVault(fv)
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetAuthMethod(&WrappedTokenAuth{WrappingToken: "wrapping-token"})
+	v2e.AddSecretItems(passwordItem())
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reads := fv.requestsTo("GET", "secret/app")
+	if len(reads) != 1 || reads[0].Token != "unwrapped-token" {
+		t.Errorf("Expected secret to be read with the unwrapped token, got %v", reads)
+	}
+}
+
+func TestWrappedTokenAuthDataToken(t *testing.T) {
+	fv := wrappingVault(t, map[string]interface{}{"data": map[string]interface{}{"token": "unwrapped-token"}})
+	defer fv.Close()
+
+	auth := &WrappedTokenAuth{WrappingToken: "wrapping-token"}
+	token, err := auth.Login(fv.client())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "unwrapped-token" {
+		t.Errorf("Expected unwrapped-token, got %s", token)
+	}
+}
+
+func TestWrappedTokenAlreadyUsed(t *testing.T) {
+	fv := wrappingVault(t, loginResponse("unwrapped-token"))
+	defer fv.Close()
+	auth := &WrappedTokenAuth{WrappingToken: "wrapping-token"}
+
+	if _, err := auth.Login(fv.client()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "has already been used") || !strings.Contains(err.Error(), "intercepted") {
+		t.Errorf("Expected already used error, got %v", err)
+	}
+}
+
+func TestWrappedTokenNoToken(t *testing.T) {
+	fv := wrappingVault(t, map[string]interface{}{"data": map[string]interface{}{"password": "hunter2"}})
+	defer fv.Close()
+	auth := &WrappedTokenAuth{WrappingToken: "wrapping-token"}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "does not contain a token") {
+		t.Errorf("Expected missing token error, got %v", err)
+	}
+}
+
+func TestAppRoleAuthWrappedSecretID(t *testing.T) {
+	fv := wrappingVault(t, map[string]interface{}{"data": map[string]interface{}{"secret_id": "unwrapped-secret"}})
+	defer fv.Close()
+	fv.respond("PUT", "auth/approle/login", 200, loginResponse("approle-token"))
+
+	auth := &AppRoleAuth{RoleID: "role", SecretIDWrappingToken: "wrapping-token"}
+	token, err := auth.Login(fv.client())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "approle-token" {
+		t.Errorf("Expected approle-token, got %s", token)
+	}
+
+	logins := fv.requestsTo("PUT", "auth/approle/login")
+	if len(logins) != 1 {
+		t.Fatalf("Expected 1 login request, got %d", len(logins))
+	}
+	if logins[0].Body["secret_id"] != "unwrapped-secret" {
+		t.Errorf("Expected unwrapped secret_id, got %v", logins[0].Body["secret_id"])
+	}
+	if logins[0].Token != "" {
+		t.Errorf("Expected login without the spent wrapping token, got '%s'", logins[0].Token)
+	}
+}
+
+func TestAppRoleAuthWrappedSecretIDConflict(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	auth := &AppRoleAuth{RoleID: "role", SecretID: "secret", SecretIDWrappingToken: "wrapping-token"}
+
+	_, err := auth.Login(fv.client())
+	if err == nil || !strings.Contains(err.Error(), "Only one of") {
+		t.Errorf("Expected conflict error, got %v", err)
+	}
+	if len(fv.requestsTo("PUT", "sys/wrapping/unwrap")) != 0 {
+		t.Errorf("Expected wrapping token not to be used")
+	}
+}
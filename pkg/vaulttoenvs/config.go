@@ -0,0 +1,170 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlTypeErrorPattern matches the line number and (possibly truncated) value of a YAML type error
+var yamlTypeErrorPattern = regexp.MustCompile("^line ([0-9]+): cannot unmarshal [^ ]+ `(.*?)(\\.\\.\\.)?`")
+
+// readSecretConfig reads and parses the secret config (string or file) from the Config
+// Unknown fields are returned as problems, so they can be reported along with any other problems
+func (v *VaultToEnvs) readSecretConfig() ([]*SecretItem, []string, error) {
+
+	var secretConfigData []byte
+	if v.config.SecretConfigFile != "" {
+		file, err := os.Open(v.config.SecretConfigFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error opening config file '%s': %v", v.config.SecretConfigFile, err)
+		}
+		defer file.Close()
+
+		secretConfigData, err = ioutil.ReadAll(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading config file '%s': %v", v.config.SecretConfigFile, err)
+		}
+	} else if v.config.SecretConfig != "" {
+		secretConfigData = []byte(v.config.SecretConfig)
+	}
+
+	if secretConfigData == nil {
+		return nil, nil, nil
+	}
+
+	return parseSecretConfig(secretConfigData, v.config.SecretConfigFile)
+}
+
+// parseSecretConfig parses a JSON or YAML secret config, chosen by the file extension.  Without a
+// .json, .yaml or .yml extension, content that looks like JSON is parsed as JSON, as JSON is also
+// valid YAML but uses different field names, and anything else as YAML
+// Unknown fields are returned as problems
+func parseSecretConfig(data []byte, fileName string) ([]*SecretItem, []string, error) {
+
+	var rawItems []map[string]interface{}
+	if isYAMLConfig(data, fileName) {
+		secretItems, err := parseYAMLSecretConfig(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		yaml.Unmarshal(data, &rawItems)
+		return withConfigTag(secretItems, "yaml"), unknownFields(rawItems, "yaml"), nil
+	}
+
+	secretItems, err := parseJSONSecretConfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	json.Unmarshal(data, &rawItems)
+	return withConfigTag(secretItems, "json"), unknownFields(rawItems, "json"), nil
+}
+
+// withConfigTag records the struct tag the items were parsed with, so problems can name their
+// fields as they appear in the config
+func withConfigTag(secretItems []*SecretItem, tag string) []*SecretItem {
+	for _, secretItem := range secretItems {
+		if secretItem != nil {
+			secretItem.configTag = tag
+		}
+	}
+	return secretItems
+}
+
+// isYAMLConfig determines whether the secret config is YAML (rather than JSON)
+func isYAMLConfig(data []byte, fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) == 0 || (trimmed[0] != '[' && trimmed[0] != '{')
+}
+
+func parseJSONSecretConfig(data []byte) ([]*SecretItem, error) {
+	var secretItems []*SecretItem
+	err := json.Unmarshal(data, &secretItems)
+	if err != nil {
+		if terr, ok := err.(*json.UnmarshalTypeError); ok {
+			line, column := jsonPosition(data, terr.Offset)
+			return nil, fmt.Errorf("Failed to parse secret config field %s (line %d, column %d): %v", terr.Field, line, column, terr)
+		}
+		if serr, ok := err.(*json.SyntaxError); ok {
+			line, column := jsonPosition(data, serr.Offset)
+			return nil, fmt.Errorf("Error parsing secret config (line %d, column %d): %v", line, column, serr)
+		}
+
+		return nil, fmt.Errorf("Error parsing secret config: %v", err)
+	}
+
+	return secretItems, nil
+}
+
+// jsonPosition converts a byte offset from a JSON error into a line and column
+// The offset is just past the failing value, so the column is that of the character before it
+func jsonPosition(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	if column < 1 {
+		column = 1
+	}
+
+	return line, column
+}
+
+func parseYAMLSecretConfig(data []byte) ([]*SecretItem, error) {
+	var secretItems []*SecretItem
+	err := yaml.Unmarshal(data, &secretItems)
+	if err != nil {
+		if terr, ok := err.(*yaml.TypeError); ok {
+			var errs []string
+			for _, e := range terr.Errors {
+				errs = append(errs, yamlTypeErrorPosition(data, e))
+			}
+			return nil, fmt.Errorf("Failed to parse secret config: %s", strings.Join(errs, "; "))
+		}
+
+		// yaml.v2 only reports the line of syntax errors, not the column
+		return nil, fmt.Errorf("Error parsing secret config: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	return secretItems, nil
+}
+
+// yamlTypeErrorPosition adds the column to a YAML type error ("line 3: cannot unmarshal...")
+// by finding the failing value on the line.  The error is returned as-is if it can't be found
+func yamlTypeErrorPosition(data []byte, typeError string) string {
+	match := yamlTypeErrorPattern.FindStringSubmatch(typeError)
+	if match == nil || match[2] == "" {
+		return typeError
+	}
+
+	lineNumber, _ := strconv.Atoi(match[1])
+	lines := strings.Split(string(data), "\n")
+	if lineNumber < 1 || lineNumber > len(lines) {
+		return typeError
+	}
+
+	index := strings.Index(lines[lineNumber-1], match[2])
+	if index < 0 {
+		return typeError
+	}
+
+	return fmt.Sprintf("line %d, column %d:%s", lineNumber, index+1, strings.TrimPrefix(typeError, "line "+match[1]+":"))
+}
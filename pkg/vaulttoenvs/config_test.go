@@ -0,0 +1,127 @@
+package vaulttoenvs
+
+import (
+	"strings"
+	"testing"
+)
+
+const jsonSecretConfig = `[
+  {
+    "vault_path": "secret/app",
+    "version": 2,
+    "namespace": "team-a",
+    "set": {
+      "PASSWORD": "password"
+    }
+  }
+]`
+
+const yamlFlowSecretConfig = `[{secretPath: secret/app, version: 2, namespace: team-a, set: {PASSWORD: password}}]`
+
+const yamlSecretConfig = `
+- secretPath: secret/app
+  version: 2
+  namespace: team-a
+  set:
+    PASSWORD: password
+`
+
+func TestParseSecretConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		fileName string
+	}{
+		{"JSON by content", jsonSecretConfig, ""},
+		{"JSON by extension", jsonSecretConfig, "secrets.json"},
+		{"YAML by content", yamlSecretConfig, ""},
+		{"YAML by .yaml extension", yamlSecretConfig, "secrets.yaml"},
+		{"YAML by .yml extension", yamlSecretConfig, "secrets.YML"},
+		{"YAML flow style with .yaml extension", yamlFlowSecretConfig, "secrets.yaml"},
+		{"JSON without extension", jsonSecretConfig, "secrets"},
+		{"JSON with other extension", jsonSecretConfig, "secrets.conf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			items, _, err := parseSecretConfig([]byte(test.data), test.fileName)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(items) != 1 {
+				t.Fatalf("Expected 1 item, got %d", len(items))
+			}
+			item := items[0]
+			if item.SecretPath != "secret/app" || item.Version != 2 || item.Namespace != "team-a" || item.SecretMaps["PASSWORD"] != "password" {
+				t.Errorf("Unexpected item: %+v", item)
+			}
+		})
+	}
+}
+
+func TestParseSecretConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		fileName string
+		error    string
+	}{
+		{
+			name:  "JSON syntax error",
+			data:  "[\n  {\n    \"vault_path\": \"secret/app\",,\n  }\n]",
+			error: "line 3, column 32",
+		},
+		{
+			name:  "JSON type error",
+			data:  "[\n  {\n    \"vault_path\": \"secret/app\",\n    \"version\": \"two\"\n  }\n]",
+			error: "field 0.version (line 4, column 20)",
+		},
+		{
+			name:  "YAML type error",
+			data:  "- secretPath: secret/app\n  version: two\n",
+			error: "line 2, column 12: cannot unmarshal !!str `two` into float64",
+		},
+		{
+			name:  "YAML type error with truncated value",
+			data:  "- secretPath: secret/app\n  ttl: a-long-value\n",
+			error: "line 2, column 8: cannot unmarshal !!str `a-long-...` into int",
+		},
+		{
+			name:     "YAML with .json extension",
+			data:     yamlSecretConfig,
+			fileName: "secrets.json",
+			error:    "Error parsing secret config (line 2, column 2)",
+		},
+		{
+			name:     "YAML syntax error",
+			data:     "- secretPath: secret/app\n  set: [\n",
+			fileName: "secrets.yml",
+			error:    "Error parsing secret config: line 2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := parseSecretConfig([]byte(test.data), test.fileName)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("Expected error containing '%s', got %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestYAMLSecretConfigFile(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, SecretConfigFile: writeTempFile(t, "secrets.yaml", "- secretPath: secret/app\n  set:\n    PASSWORD: password\n")})
+	v2e.SetVaultToken("token")
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(envs) != 1 || envs[0] != "PASSWORD=hunter2" {
+		t.Errorf("Unexpected envs: %v", envs)
+	}
+}
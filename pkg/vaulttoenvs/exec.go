@@ -0,0 +1,278 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// credentialEnvVars are the env vars v2e logs in to Vault with, which are removed from the command's
+// environment so it isn't handed v2e's Vault token or auth credentials
+var credentialEnvVars = []string{"VAULT_TOKEN", "VAULT_WRAPPED_TOKEN", "VAULT_ROLE_ID", "VAULT_SECRET_ID", "VAULT_JWT"}
+
+// stopTimeout is how long a command is given to exit before being killed when restarting it
+var stopTimeout = 30 * time.Second
+
+// commandExit is the result of waiting for a command to exit
+type commandExit struct {
+	status syscall.WaitStatus
+	err    error
+}
+
+// Exec loads the secrets and replaces the current process with the command, with the secrets
+// added to the current environment.  It only returns if something fails
+func (v *VaultToEnvs) Exec(args []string) error {
+
+	path, err := lookCommand(args)
+	if err != nil {
+		return err
+	}
+
+	err = v.loadSecrets()
+	if err != nil {
+		return err
+	}
+
+	v.log.Debug("Executing ", path)
+	err = syscall.Exec(path, args, mergeEnv(os.Environ(), v.envVars()))
+
+	// Leases would otherwise be left issued but unused
+	return v.revokeOnFailure(fmt.Errorf("Error executing %s: %s", args[0], err.Error()))
+}
+
+// Run loads the secrets and runs the command as a child process, with the secrets added to the
+// current environment.  While the command runs, the Vault token and leases are renewed and, if
+// either reaches its max TTL, Config.MaxTTLPolicy is applied.  Signals to v2e (SIGTERM,
+// SIGINT, SIGHUP, SIGQUIT, SIGUSR1 and SIGUSR2) are passed on to the command and, if v2e is PID 1,
+// orphaned processes are reaped.  Returns the exit code of the command once it exits, which is
+// 128 + the signal number if it was killed by a signal
+func (v *VaultToEnvs) Run(args []string) (int, error) {
+
+	path, err := lookCommand(args)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkMaxTTLPolicy(v.config.MaxTTLPolicy)
+	if err != nil {
+		return 0, err
+	}
+
+	err = v.loadSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	// Signals to v2e are passed on to the command, so v2e can clean up once the command exits
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardSignals...)
+	defer signal.Stop(signals)
+
+	for {
+		cmd, exited, err := v.startCommand(path, args)
+		if err != nil {
+			return 0, v.revokeOnFailure(err)
+		}
+
+		expired, stopRenewing, err := v.renewLeases()
+		if err != nil {
+			v.stopCommand(cmd, exited)
+			return 0, v.revokeOnFailure(err)
+		}
+
+		restart := false
+		for !restart {
+			select {
+			case exit := <-exited:
+				stopRenewing()
+				return v.commandExited(cmd, exit)
+
+			case sig := <-signals:
+				v.log.Debug("Forwarding ", sig, " to ", args[0])
+				if err := cmd.Process.Signal(sig); err != nil {
+					v.log.Warn(fmt.Sprintf("Error signalling %s: %s", args[0], err.Error()))
+				}
+
+			case expiry := <-expired:
+				if expiry.err != nil && expiry.lease == nil {
+					v.log.Warn(fmt.Sprintf("Vault token has expired, as renewing it failed: %s", expiry.err.Error()))
+				} else if expiry.err != nil {
+					v.log.Warn(fmt.Sprintf("Lease for %s has expired, as renewing it failed: %s", expiry.lease.path, expiry.err.Error()))
+				} else if expiry.lease == nil {
+					v.log.Warn("Vault token has reached its max TTL and will expire")
+				} else {
+					v.log.Warn(fmt.Sprintf("Lease for %s has reached its max TTL and will expire", expiry.lease.path))
+				}
+
+				switch v.config.MaxTTLPolicy {
+				case MaxTTLSignal:
+					v.log.Info("Sending SIGTERM to ", args[0])
+					if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+						v.log.Warn(fmt.Sprintf("Error signalling %s: %s", args[0], err.Error()))
+					}
+				case MaxTTLRestart:
+					restart = v.restartCommand(cmd, exited, stopRenewing, expiry.lease == nil)
+				}
+			}
+		}
+	}
+}
+
+// restartCommand reads new secrets, logging in again first if the token expired, then stops the
+// command and revokes the old leases (and token) so it can be started again with the new secrets
+// Returns false, leaving the command running with the old secrets, if new secrets can't be read
+func (v *VaultToEnvs) restartCommand(cmd *exec.Cmd, exited <-chan commandExit, stopRenewing func(), tokenExpired bool) bool {
+
+	// A new token can only be obtained by logging in again with the auth method, which isn't
+	// possible for single use credentials such as wrapping tokens
+	if tokenExpired && !canLogInAgain(v.config.authMethod) {
+		v.log.Warn("Not restarting ", cmd.Args[0], ", as a new Vault token can only be obtained with an auth method that can log in again")
+		return false
+	}
+
+	// The token is still valid unless it was what expired, so only log in again if it was
+	oldClient, oldLeases := v.vaultClient, v.leases
+	err := v.readSecrets(tokenExpired)
+	if err != nil {
+		v.log.Warn(fmt.Sprintf("Not restarting %s, as reading new secrets failed: %s", cmd.Args[0], err.Error()))
+		if v.vaultClient != oldClient {
+			v.revokeToken(v.vaultClient)
+		}
+		v.vaultClient, v.leases = oldClient, oldLeases
+		return false
+	}
+
+	v.log.Info("Restarting ", cmd.Args[0], " with new secrets")
+	stopRenewing()
+	v.stopCommand(cmd, exited)
+
+	// The old leases (and token, if it expired) are no longer used
+	_, err = v.revokeLeases(oldClient, oldLeases)
+	if err != nil {
+		v.log.Warn(err.Error())
+	}
+	if tokenExpired {
+		v.revokeToken(oldClient)
+	}
+
+	return true
+}
+
+// commandExited returns the exit code of the command, revoking the leases first if
+// Config.RevokeOnExit is set
+func (v *VaultToEnvs) commandExited(cmd *exec.Cmd, exit commandExit) (int, error) {
+
+	if v.config.RevokeOnExit {
+		if err := v.RevokeLeases(); err != nil {
+			v.log.Warn(err.Error())
+		}
+	}
+
+	if exit.err != nil {
+		return 0, fmt.Errorf("Error waiting for %s: %s", cmd.Args[0], exit.err.Error())
+	}
+
+	// Follow the shell convention for commands killed by a signal
+	if exit.status.Signaled() {
+		v.log.Info(cmd.Args[0], " was killed by signal ", exit.status.Signal())
+		return 128 + int(exit.status.Signal()), nil
+	}
+
+	return exit.status.ExitStatus(), nil
+}
+
+// startCommand starts the command with the secrets added to the current environment
+// The result of waiting for the command is sent on the returned channel once it exits
+func (v *VaultToEnvs) startCommand(path string, args []string) (*exec.Cmd, <-chan commandExit, error) {
+
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Env = mergeEnv(os.Environ(), v.envVars())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	v.log.Debug("Running ", path)
+	err := cmd.Start()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error running %s: %s", args[0], err.Error())
+	}
+
+	exited := make(chan commandExit, 1)
+	go func() {
+		if reapChildren {
+			exited <- v.waitReaping(cmd.Process.Pid)
+			return
+		}
+
+		err := cmd.Wait()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			exited <- commandExit{err: err}
+			return
+		}
+		exited <- commandExit{status: cmd.ProcessState.Sys().(syscall.WaitStatus)}
+	}()
+
+	return cmd, exited, nil
+}
+
+// stopCommand sends the command SIGTERM and waits for it to exit, killing it if it doesn't
+// exit within stopTimeout
+func (v *VaultToEnvs) stopCommand(cmd *exec.Cmd, exited <-chan commandExit) {
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		v.log.Warn(fmt.Sprintf("Error signalling %s: %s", cmd.Args[0], err.Error()))
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		v.log.Warn(cmd.Args[0], " did not exit after ", stopTimeout, ", killing it")
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// lookCommand finds the path of the command to run
+func lookCommand(args []string) (string, error) {
+
+	if len(args) < 1 || args[0] == "" {
+		return "", fmt.Errorf("No command given to run")
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return "", fmt.Errorf("Error finding command %s: %s", args[0], err.Error())
+	}
+
+	return path, nil
+}
+
+// mergeEnv adds the env vars to the environment, replacing any existing values, and removes v2e's
+// Vault credentials unless they are set by the env vars
+func mergeEnv(environ []string, envVars []envVar) []string {
+
+	removedNames := make(map[string]bool)
+	for _, name := range credentialEnvVars {
+		removedNames[name] = true
+	}
+	for _, env := range envVars {
+		removedNames[env.name] = true
+	}
+
+	var result []string
+	for _, entry := range environ {
+		if !removedNames[strings.SplitN(entry, "=", 2)[0]] {
+			result = append(result, entry)
+		}
+	}
+	for _, env := range envVars {
+		result = append(result, env.name+"="+env.value)
+	}
+
+	return result
+}
@@ -0,0 +1,54 @@
+package vaulttoenvs
+
+import (
+	"syscall"
+	"testing"
+)
+
+// prSetChildSubreaper is the prctl option making orphaned descendants children of the process
+const prSetChildSubreaper = 36
+
+// setSubreaper makes the test process a subreaper, so orphaned processes are reparented to it
+// as they would be to v2e as PID 1
+func setSubreaper(t *testing.T, enabled bool) {
+	var arg uintptr
+	if enabled {
+		arg = 1
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, arg, 0); errno != 0 {
+		t.Skipf("Unable to set child subreaper: %v", errno)
+	}
+}
+
+func TestRunReapsOrphans(t *testing.T) {
+	setSubreaper(t, true)
+	defer setSubreaper(t, false)
+	reapChildren = true
+	defer func() { reapChildren = false }()
+
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	// The subshell exits straight away, leaving sleep orphaned
+	exitCode, err := v2e.Run([]string{"sh", "-c", "(sleep 0.1 &); sleep 1; exit 6"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 6 {
+		t.Errorf("Expected exit code 6, got %d", exitCode)
+	}
+	if !logger.contains("debug: Reaped process") {
+		t.Errorf("Expected the orphaned process to be reaped, got %v", logger.messages)
+	}
+
+	// Nothing is left to reap
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil); err != syscall.ECHILD {
+		t.Errorf("Expected no child processes left, got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+//go:build !windows
+// +build !windows
+
+package vaulttoenvs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMergeEnv(t *testing.T) {
+	environ := []string{"HOME=/root", "PASSWORD=old", "VAULT_TOKEN=token", "VAULT_SECRET_ID=secret", "VAULT_ROLE_ID=role", "EMPTY=", "PATH=/bin"}
+	envVars := []envVar{{name: "PASSWORD", value: "new=value"}, {name: "USER", value: "app"}, {name: "VAULT_ROLE_ID", value: "app-role"}}
+
+	// v2e's credentials are removed, unless set by a secret
+	expected := []string{"HOME=/root", "EMPTY=", "PATH=/bin", "PASSWORD=new=value", "USER=app", "VAULT_ROLE_ID=app-role"}
+	if result := mergeEnv(environ, envVars); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+// TestExecHelper is run as a separate process by TestExec, as Exec replaces the process
+func TestExecHelper(t *testing.T) {
+	vaultAddr := os.Getenv("V2E_TEST_EXEC_VAULT")
+	if vaultAddr == "" {
+		t.Skip("Only run by TestExec")
+	}
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: vaultAddr})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	err := v2e.Exec([]string{"sh", "-c", `printf '%s %s' "$PASSWORD" "$0"`})
+	t.Fatalf("Exec returned: %v", err)
+}
+
+func TestExec(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExecHelper$")
+	cmd.Env = append(os.Environ(), "V2E_TEST_EXEC_VAULT="+fv.URL, "PASSWORD=old")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Error running helper: %v: %s", err, output)
+	}
+
+	if string(output) != "hunter2 sh" {
+		t.Errorf("Expected the command to replace the process with the secret set, got %q", output)
+	}
+}
+
+func TestExecCommandNotFound(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	err := v2e.Exec([]string{"v2e-no-such-command"})
+	if err == nil || !strings.Contains(err.Error(), "Error finding command v2e-no-such-command") {
+		t.Fatalf("Expected command not found error, got %v", err)
+	}
+	if len(fv.requests) != 0 {
+		t.Errorf("Expected no requests to Vault, got %d", len(fv.requests))
+	}
+}
+
+func TestRun(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+	outputFile := tempDir(t) + "/output"
+	env := testEnv{}
+	defer env.restore()
+	env.set("PASSWORD", "old")
+	env.set("VAULT_TOKEN", "token")
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", `printf %s "$PASSWORD ${VAULT_TOKEN-unset}" > "$1"; exit 3`, "sh", outputFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitCode)
+	}
+
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	if string(output) != "hunter2 unset" {
+		t.Errorf("Expected the secret to be set and VAULT_TOKEN removed, got %q", output)
+	}
+}
+
+func TestRunNoCommand(t *testing.T) {
+	v2e := NewVaultToEnvs(&Config{})
+	if _, err := v2e.Run(nil); err == nil || err.Error() != "No command given to run" {
+		t.Errorf("Expected no command error, got %v", err)
+	}
+}
+
+// A failure to load secrets is returned without running the command
+func TestRunLoadFailure(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+	outputFile := tempDir(t) + "/output"
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "missing"}})
+
+	_, err := v2e.Run([]string{"touch", outputFile})
+	if err == nil || !strings.Contains(err.Error(), "Key missing not found") {
+		t.Fatalf("Expected missing key error, got %v", err)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the command not to run")
+	}
+}
+
+// runDatabaseCommand runs the command with database credentials, revoking them on exit if set
+func runDatabaseCommand(t *testing.T, fv *fakeVault, revokeOnExit bool, args ...string) (int, *testLogger) {
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, RevokeOnExit: revokeOnExit})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	exitCode, err := v2e.Run(args)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return exitCode, logger
+}
+
+func TestRevokeOnExit(t *testing.T) {
+	tests := []struct {
+		name         string
+		revokeOnExit bool
+		revokes      int
+	}{
+		{name: "revoke on exit", revokeOnExit: true, revokes: 1},
+		{name: "default", revokeOnExit: false, revokes: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"database/": databaseMount})
+			fv.dynamicSecret("database/creds/app", "database/creds/app/lease", map[string]interface{}{"username": "app-user"})
+
+			exitCode, _ := runDatabaseCommand(t, fv, test.revokeOnExit, "sh", "-c", "exit 2")
+			if exitCode != 2 {
+				t.Errorf("Expected exit code 2, got %d", exitCode)
+			}
+			if revokes := len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease")); revokes != test.revokes {
+				t.Errorf("Expected %d revokes, got %d", test.revokes, revokes)
+			}
+		})
+	}
+}
+
+// SIGTERM sent to v2e is passed on to the command, and the leases revoked once it exits
+func TestRevokeOnExitAfterSignal(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.dynamicSecret("database/creds/app", "database/creds/app/lease", map[string]interface{}{"username": "app-user"})
+	readyFile := tempDir(t) + "/ready"
+
+	go func() {
+		waitForFile(readyFile)
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}()
+
+	start := time.Now()
+	exitCode, _ := runDatabaseCommand(t, fv, true, "sh", "-c", `trap 'exit 0' TERM; touch "$1"; sleep 10 & wait`, "sh", readyFile)
+	if exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected SIGTERM to be passed on to the command")
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease")) != 1 {
+		t.Errorf("Expected the lease to be revoked")
+	}
+}
+
+// A failure to revoke is logged, and the command's exit code still returned
+func TestRevokeOnExitFailure(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.respond("GET", "database/creds/app", 200, map[string]interface{}{
+		"lease_id":       "database/creds/app/lease",
+		"lease_duration": 3600,
+		"renewable":      true,
+		"data":           map[string]interface{}{"username": "app-user"},
+	})
+	fv.respond("PUT", "sys/leases/revoke/database/creds/app/lease", 500, map[string]interface{}{"errors": []string{"backend unavailable"}})
+
+	exitCode, logger := runDatabaseCommand(t, fv, true, "sh", "-c", "exit 3")
+	if exitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitCode)
+	}
+	if !logger.contains("warn: Error revoking leases: database/creds/app/lease") {
+		t.Errorf("Expected the revoke error to be logged, got %v", logger.messages)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected int
+	}{
+		{name: "success", script: "exit 0", expected: 0},
+		{name: "failure", script: "exit 1", expected: 1},
+		{name: "SIGTERM", script: "kill -TERM $$", expected: 128 + 15},
+		{name: "SIGKILL", script: "kill -KILL $$", expected: 128 + 9},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := genericSecretVault(newFakeVault(t))
+			defer fv.Close()
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(passwordItem())
+
+			exitCode, err := v2e.Run([]string{"sh", "-c", test.script})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if exitCode != test.expected {
+				t.Errorf("Expected exit code %d, got %d", test.expected, exitCode)
+			}
+		})
+	}
+}
+
+func TestRunForwardsSignals(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+	dir := tempDir(t)
+	outputFile := dir + "/output"
+	readyFile := dir + "/ready"
+
+	// Records each signal received, exiting once all have been
+	script := `for sig in HUP INT QUIT USR1 USR2 TERM; do trap "echo $sig >> \"\$1\"" $sig; done
+touch "$2"
+while [ "$(cat "$1" 2>/dev/null | wc -l)" -lt 6 ]; do sleep 0.05; done`
+
+	signals := []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM}
+	go func() {
+		waitForFile(readyFile)
+		for _, sig := range signals {
+			syscall.Kill(os.Getpid(), sig)
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", script, "sh", outputFile, readyFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	received := strings.Fields(string(output))
+	sort.Strings(received)
+	expected := []string{"HUP", "INT", "QUIT", "TERM", "USR1", "USR2"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected signals %v to be forwarded, got %v", expected, received)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package vaulttoenvs
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardSignals are the signals passed on to a command run with Run
+var forwardSignals = []os.Signal{
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// reapChildren is set when v2e is PID 1 (e.g. a container entrypoint), in which case orphaned
+// processes are reparented to v2e and have to be reaped by it
+var reapChildren = os.Getpid() == 1
+
+// waitReaping waits for the process with the pid to exit, reaping any other child processes
+// that exit in the meantime
+func (v *VaultToEnvs) waitReaping(pid int) commandExit {
+	for {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return commandExit{err: os.NewSyscallError("wait4", err)}
+		}
+
+		if wpid == pid {
+			return commandExit{status: status}
+		}
+		v.log.Debug("Reaped process ", wpid)
+	}
+}
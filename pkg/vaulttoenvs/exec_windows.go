@@ -0,0 +1,17 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"os"
+)
+
+// forwardSignals are the signals passed on to a command run with Run
+var forwardSignals = []os.Signal{os.Interrupt}
+
+// reapChildren is never set on Windows, which has no zombie processes
+var reapChildren = false
+
+// waitReaping is only used when reapChildren is set
+func (v *VaultToEnvs) waitReaping(pid int) commandExit {
+	return commandExit{err: fmt.Errorf("Reaping child processes is not supported on Windows")}
+}
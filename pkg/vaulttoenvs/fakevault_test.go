@@ -0,0 +1,356 @@
+package vaulttoenvs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// fakeRequest records a request received by the fake Vault server
+type fakeRequest struct {
+	Method    string
+	Path      string
+	Token     string
+	Body      map[string]interface{}
+	CertCN    string // common name of the TLS client certificate, if any
+	Namespace string
+}
+
+// fakeHandler returns the status code and JSON response body for a request
+type fakeHandler func(req fakeRequest) (int, interface{})
+
+// fakeVault is a minimal Vault HTTP API for testing against
+type fakeVault struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	handlers map[string]fakeHandler
+	requests []fakeRequest
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	f := &fakeVault{
+		t:        t,
+		handlers: make(map[string]fakeHandler),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// newFakeVaultTLS starts a fake Vault server using TLS, which requests (but doesn't require)
+// a client certificate
+func newFakeVaultTLS(t *testing.T) *fakeVault {
+	f := &fakeVault{
+		t:        t,
+		handlers: make(map[string]fakeHandler),
+	}
+	f.Server = httptest.NewUnstartedServer(http.HandlerFunc(f.serveHTTP))
+	f.Server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	f.Server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	f.StartTLS()
+	return f
+}
+
+// caCertFile writes the server's certificate to a PEM file and returns its path
+func (f *fakeVault) caCertFile() string {
+	return writeTempFile(f.t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw})))
+}
+
+// handle registers a handler for the method and path (without the /v1/ prefix)
+func (f *fakeVault) handle(method string, path string, handler fakeHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[method+" "+path] = handler
+}
+
+// respond registers a static response for the method and path
+func (f *fakeVault) respond(method string, path string, status int, body interface{}) {
+	f.handle(method, path, func(fakeRequest) (int, interface{}) {
+		return status, body
+	})
+}
+
+// mounts registers the sys/mounts response
+func (f *fakeVault) mounts(mounts map[string]interface{}) {
+	f.respond("GET", "sys/mounts", 200, map[string]interface{}{"data": mounts})
+}
+
+// Mount table entries for the secrets engines of the fake Vault
+var (
+	genericMount  = map[string]interface{}{"type": "generic"}
+	kv1Mount      = map[string]interface{}{"type": "kv", "options": map[string]interface{}{"version": "1"}}
+	kv2Mount      = map[string]interface{}{"type": "kv", "options": map[string]interface{}{"version": "2"}}
+	databaseMount = map[string]interface{}{"type": "database"}
+)
+
+// genericSecret registers a non-versioned (generic or KV version 1) secret
+func (f *fakeVault) genericSecret(secretPath string, data map[string]interface{}) {
+	f.respond("GET", secretPath, 200, map[string]interface{}{"data": data})
+}
+
+// kv2Secret registers a KV version 2 secret whose latest version is version, along with its
+// metadata.  The path is given without the data/ segment, e.g. kv/app for kv/data/app
+func (f *fakeVault) kv2Secret(secretPath string, version int, data map[string]interface{}) {
+	parts := strings.SplitN(secretPath, "/", 2)
+
+	versions := make(map[string]interface{})
+	for i := 1; i <= version; i++ {
+		versions[strconv.Itoa(i)] = map[string]interface{}{"deletion_time": "", "destroyed": false}
+	}
+
+	f.respond("GET", parts[0]+"/data/"+parts[1], 200, map[string]interface{}{
+		"data": map[string]interface{}{
+			"data":     data,
+			"metadata": map[string]interface{}{"version": version},
+		},
+	})
+	f.respond("GET", parts[0]+"/metadata/"+parts[1], 200, map[string]interface{}{
+		"data": map[string]interface{}{"versions": versions},
+	})
+}
+
+// kv2List registers the keys listed under a folder of a KV version 2 secrets engine, given
+// without the metadata/ segment like kv2Secret.  Keys ending in / are sub-folders
+func (f *fakeVault) kv2List(folderPath string, keys ...string) {
+	parts := strings.SplitN(folderPath, "/", 2)
+	f.respond("LIST", parts[0]+"/metadata/"+parts[1], 200, map[string]interface{}{
+		"data": map[string]interface{}{"keys": keys},
+	})
+}
+
+// dynamicSecret registers a renewable dynamic secret with the lease, which can be revoked
+func (f *fakeVault) dynamicSecret(secretPath string, leaseID string, data map[string]interface{}) {
+	f.respond("GET", secretPath, 200, map[string]interface{}{
+		"lease_id":       leaseID,
+		"lease_duration": 3600,
+		"renewable":      true,
+		"data":           data,
+	})
+	f.respond("PUT", "sys/leases/revoke/"+leaseID, 204, nil)
+}
+
+// wrappedResponse registers a response wrapped with the wrapping token "wrapping-token", which
+// can only be unwrapped once
+func (f *fakeVault) wrappedResponse(response map[string]interface{}) {
+	used := false
+	f.handle("PUT", "sys/wrapping/unwrap", func(req fakeRequest) (int, interface{}) {
+		if req.Token != "wrapping-token" || used {
+			return 400, map[string]interface{}{"errors": []string{"wrapping token is not valid or does not exist"}}
+		}
+		used = true
+		return 200, response
+	})
+}
+
+// requestsTo returns the recorded requests for the method and path
+func (f *fakeVault) requestsTo(method string, path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []fakeRequest
+	for _, req := range f.requests {
+		if req.Method == method && req.Path == path {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// client returns an unauthenticated Vault client for the fake server
+func (f *fakeVault) client() *VaultApi.Client {
+	client, err := VaultApi.NewClient(&VaultApi.Config{Address: f.URL})
+	if err != nil {
+		f.t.Fatalf("Error creating Vault client: %v", err)
+	}
+	client.ClearToken()
+	return client
+}
+
+func (f *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := fakeRequest{
+		Method:    r.Method,
+		Path:      strings.TrimPrefix(r.URL.Path, "/v1/"),
+		Token:     r.Header.Get("X-Vault-Token"),
+		Namespace: r.Header.Get("X-Vault-Namespace"),
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		req.CertCN = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	// The Vault client sends writes as PUT
+	if req.Method == "POST" {
+		req.Method = "PUT"
+	}
+
+	// and lists as GET with list=true
+	if r.URL.Query().Get("list") == "true" {
+		req.Method = "LIST"
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req.Body); err != nil {
+			f.t.Errorf("Invalid JSON body sent to %s: %v", req.Path, err)
+		}
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	handler, ok := f.handlers[req.Method+" "+req.Path]
+	f.mu.Unlock()
+
+	status := 404
+	var response interface{} = map[string]interface{}{"errors": []string{}}
+	if ok {
+		status, response = handler(req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// testLogger records log messages
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) log(level string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+": "+fmt.Sprint(args...))
+}
+
+func (l *testLogger) Debug(args ...interface{}) { l.log("debug", args...) }
+func (l *testLogger) Info(args ...interface{})  { l.log("info", args...) }
+func (l *testLogger) Warn(args ...interface{})  { l.log("warn", args...) }
+func (l *testLogger) Fatal(args ...interface{}) { l.log("fatal", args...) }
+
+// contains returns true if a message containing the text was logged
+func (l *testLogger) contains(text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range l.messages {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginResponse is a successful login response with the given token
+func loginResponse(token string) map[string]interface{} {
+	return map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token": token,
+		},
+	}
+}
+
+// testDir holds the temporary files written by tests, and is removed once they have all run
+var testDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	testDir, err = ioutil.TempDir("", "vaulttoenvs-test")
+	if err != nil {
+		stdlog.Fatalf("Error creating test directory: %v", err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+// tempDir creates a new temporary directory for a test
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(testDir, "")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	return dir
+}
+
+// testEnv sets env vars for a test, recording their original values so they can be restored
+type testEnv map[string]*string
+
+// set sets the env var, recording its original value the first time it's set
+func (e testEnv) set(key string, value string) {
+	if _, ok := e[key]; !ok {
+		if original, ok := os.LookupEnv(key); ok {
+			e[key] = &original
+		} else {
+			e[key] = nil
+		}
+	}
+	os.Setenv(key, value)
+}
+
+// restore restores the original values of the env vars set
+func (e testEnv) restore() {
+	for key, original := range e {
+		if original == nil {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, *original)
+		}
+	}
+}
+
+// writeTempFile writes contents to a file in a temporary directory and returns its path
+func writeTempFile(t *testing.T, name string, contents string) string {
+	path := tempDir(t) + "/" + name
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", path, err)
+	}
+	return path
+}
+
+// writeClientCert writes a self-signed client certificate and key and returns their paths
+func writeClientCert(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+
+	certFile := writeTempFile(t, "client.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})))
+	keyFile := writeTempFile(t, "client-key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})))
+	return certFile, keyFile
+}
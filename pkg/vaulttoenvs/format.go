@@ -0,0 +1,253 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats supported by DisplayEnvExports
+const (
+	FormatShell      = "shell"      // POSIX shell export statements (the default)
+	FormatDotenv     = "dotenv"     // .env file, as read by docker-compose and dotenv libraries
+	FormatJSON       = "json"       // JSON object of env var names to values, or list of env vars with metadata
+	FormatYAML       = "yaml"       // YAML map of env var names to values, or list of env vars with metadata
+	FormatFish       = "fish"       // fish shell set -gx statements
+	FormatPowerShell = "powershell" // PowerShell $Env: assignments
+	FormatCmd        = "cmd"        // Windows cmd.exe set statements
+)
+
+// dotenvReplacer escapes a value for use in a double quoted dotenv value, in the way godotenv and
+// docker compose unescape them.  python-dotenv unescapes all of these but \$
+var dotenvReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`$`, `\$`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// fishReplacer escapes a value for use in a fish single quoted string
+var fishReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+)
+
+// powerShellReplacer escapes a value for use in a PowerShell single quoted string, which also
+// treats the curly single quotes as quotes
+var powerShellReplacer = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201A", "\u201A\u201A",
+	"\u201B", "\u201B\u201B",
+)
+
+// envVarMetadata is an env var along with where it was read from, for the json and yaml formats
+type envVarMetadata struct {
+	Name          string `json:"name" yaml:"name"`
+	Value         string `json:"value" yaml:"value"`
+	SecretPath    string `json:"vault_path" yaml:"secretPath"`
+	Key           string `json:"key" yaml:"key"`
+	KVVersion     int    `json:"kv_version,omitempty" yaml:"kvVersion,omitempty"` // 0 for dynamic secrets
+	LeaseID       string `json:"lease_id,omitempty" yaml:"leaseId,omitempty"`
+	LeaseDuration int    `json:"lease_duration,omitempty" yaml:"leaseDuration,omitempty"`
+}
+
+// checkFormat returns an error if the output format isn't supported
+func checkFormat(format string, metadata bool) error {
+	switch format {
+	case "", FormatShell, FormatDotenv, FormatFish, FormatPowerShell, FormatCmd:
+		if metadata {
+			return fmt.Errorf("Metadata can only be output in the %s and %s formats", FormatJSON, FormatYAML)
+		}
+		return nil
+	case FormatJSON, FormatYAML:
+		return nil
+	}
+	return fmt.Errorf("Unknown output format '%s'", format)
+}
+
+// writeEnvVars writes the env vars to w in the output format
+// If metadata is set, the json and yaml formats output a list of env vars with their source and lease
+func writeEnvVars(w io.Writer, format string, metadata bool, envVars []envVar) error {
+
+	if err := checkFormat(format, metadata); err != nil {
+		return err
+	}
+
+	var output []byte
+	var err error
+	switch format {
+	case FormatJSON:
+		output, err = formatJSON(envVars, metadata)
+	case FormatYAML:
+		output, err = formatYAML(envVars, metadata)
+	default:
+		output, err = formatLines(envVars, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(output); err != nil {
+		return fmt.Errorf("Error writing output: %s", err.Error())
+	}
+
+	return nil
+}
+
+// formatLines formats the env vars one per line, for shells and dotenv files
+func formatLines(envVars []envVar, format string) ([]byte, error) {
+
+	var output bytes.Buffer
+	for _, env := range envVars {
+		switch format {
+		case FormatDotenv:
+			fmt.Fprintf(&output, "%s=%s\n", env.name, dotenvQuote(env.value))
+		case FormatFish:
+			fmt.Fprintf(&output, "set -gx %s '%s'\n", env.name, fishReplacer.Replace(env.value))
+		case FormatPowerShell:
+			fmt.Fprintf(&output, "$Env:%s = '%s'\n", env.name, powerShellReplacer.Replace(env.value))
+		case FormatCmd:
+			value, err := cmdQuote(env.value)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot output env var %s: %s", env.name, err.Error())
+			}
+			fmt.Fprintf(&output, "set \"%s=%s\"\r\n", env.name, value)
+		default:
+			// Single quotes value and escapes single quotes in secret with '"'"'
+			fmt.Fprintf(&output, "export %s='%s'\n", env.name, strings.Replace(env.value, "'", "'\"'\"'", -1))
+		}
+	}
+
+	return output.Bytes(), nil
+}
+
+// dotenvQuote quotes a value for a dotenv file
+// Values are single quoted where possible, rather than written as NAME="value", as godotenv, docker
+// compose and python-dotenv all read single quoted values as they are (python-dotenv only expands
+// ${NAME} in them), but don't agree on escaping $ in double quoted values.  Values with single
+// quotes, backslashes or line breaks are double quoted and escaped instead
+func dotenvQuote(value string) string {
+	if !strings.ContainsAny(value, "'\\\r\n") {
+		return "'" + value + "'"
+	}
+	return "\"" + dotenvReplacer.Replace(value) + "\""
+}
+
+// cmdQuote escapes a value for use in a cmd.exe set "NAME=value" statement
+// Quotes in the value switch quoting on and off, so special characters outside of quotes are escaped
+// with ^.  % is doubled, as in batch files.  Line breaks can't be set with cmd.exe
+func cmdQuote(value string) (string, error) {
+
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("Values containing line breaks are not supported by the %s format", FormatCmd)
+	}
+
+	var quoted strings.Builder
+	inQuotes := true
+	for _, c := range value {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '%':
+			quoted.WriteRune('%')
+		case !inQuotes && strings.ContainsRune("^&|<>()", c):
+			quoted.WriteRune('^')
+		}
+		quoted.WriteRune(c)
+	}
+
+	return quoted.String(), nil
+}
+
+// formatJSON formats the env vars as a JSON object in output order, or a list with metadata
+func formatJSON(envVars []envVar, metadata bool) ([]byte, error) {
+
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	if metadata {
+		if err := encoder.Encode(withMetadata(envVars)); err != nil {
+			return nil, fmt.Errorf("Error formatting output: %s", err.Error())
+		}
+		return output.Bytes(), nil
+	}
+
+	// Maps are encoded in key order, so the object is written by hand to keep the output order
+	output.WriteString("{")
+	for i, env := range envVars {
+		if i > 0 {
+			output.WriteString(",")
+		}
+		output.WriteString("\n  ")
+		if err := encoder.Encode(env.name); err != nil {
+			return nil, fmt.Errorf("Error formatting output: %s", err.Error())
+		}
+		output.Truncate(output.Len() - 1)
+		output.WriteString(": ")
+		if err := encoder.Encode(env.value); err != nil {
+			return nil, fmt.Errorf("Error formatting output: %s", err.Error())
+		}
+		output.Truncate(output.Len() - 1)
+	}
+	if len(envVars) > 0 {
+		output.WriteString("\n")
+	}
+	output.WriteString("}\n")
+
+	return output.Bytes(), nil
+}
+
+// formatYAML formats the env vars as a YAML map in output order, or a list with metadata
+func formatYAML(envVars []envVar, metadata bool) ([]byte, error) {
+
+	var document interface{}
+	if metadata {
+		document = withMetadata(envVars)
+	} else {
+		values := yaml.MapSlice{}
+		for _, env := range envVars {
+			values = append(values, yaml.MapItem{Key: env.name, Value: env.value})
+		}
+		document = values
+	}
+
+	output, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("Error formatting output: %s", err.Error())
+	}
+
+	return output, nil
+}
+
+// withMetadata adds the source and lease of each env var
+func withMetadata(envVars []envVar) []envVarMetadata {
+
+	result := []envVarMetadata{}
+	for _, env := range envVars {
+		item := envVarMetadata{
+			Name:       env.name,
+			Value:      env.value,
+			SecretPath: env.secretPath,
+			Key:        env.key,
+		}
+		if env.secretItem != nil {
+			item.KVVersion = env.secretItem.kvVersion
+			if env.secretItem.secret != nil {
+				item.LeaseID = env.secretItem.secret.LeaseID
+				item.LeaseDuration = env.secretItem.secret.LeaseDuration
+			}
+		}
+		result = append(result, item)
+	}
+
+	return result
+}
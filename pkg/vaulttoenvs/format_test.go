@@ -0,0 +1,592 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	VaultApi "github.com/hashicorp/vault/api"
+	"gopkg.in/yaml.v2"
+)
+
+// hostileValues are secret values that need escaping in most output formats
+var hostileValues = map[string]string{
+	"plain":            "hunter2",
+	"empty":            "",
+	"single quote":     "it's",
+	"double quote":     `say "hi"`,
+	"backslash":        `C:\path\`,
+	"dollar":           "$HOME ${PATH} $(id) `id`",
+	"newline":          "line1\nline2\n",
+	"carriage return":  "line1\r\nline2",
+	"unicode":          "pässwörd ✓ 密码 🔑",
+	"smart quotes":     "‘left’ “right”",
+	"percent and hash": "100% #not-a-comment !bang",
+	"shell operators":  "a;b|c&d>e<f^g",
+	"mixed quotes":     `'"'"' "a&b" ‛x‚ \'`,
+}
+
+func TestWriteEnvVars(t *testing.T) {
+	tests := []struct {
+		format   string
+		value    string
+		expected string
+	}{
+		{format: FormatShell, value: "hunter2", expected: "export NAME='hunter2'\n"},
+		{format: FormatShell, value: "it's", expected: "export NAME='it'\"'\"'s'\n"},
+		{format: FormatShell, value: "$HOME\n", expected: "export NAME='$HOME\n'\n"},
+		{format: "", value: "hunter2", expected: "export NAME='hunter2'\n"},
+		{format: FormatDotenv, value: "hunter2", expected: "NAME='hunter2'\n"},
+		{format: FormatDotenv, value: "it's", expected: `NAME="it's"` + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format+" "+test.value, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeEnvVars(&out, test.format, false, []envVar{{name: "NAME", value: test.value}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, out.String())
+			}
+		})
+	}
+}
+
+// dotenvHostileLines are the dotenv lines written for hostileValues.  These lines were read with
+// godotenv v1.5.1, docker compose's parser (compose-go v2.1.3) and python-dotenv 1.1.0 to record
+// dotenvLossyValues, so they have to be read again if the lines written change
+var dotenvHostileLines = map[string]string{
+	"plain":            `NAME='hunter2'`,
+	"empty":            `NAME=''`,
+	"single quote":     `NAME="it's"`,
+	"double quote":     `NAME='say "hi"'`,
+	"backslash":        `NAME="C:\\path\\"`,
+	"dollar":           "NAME='$HOME ${PATH} $(id) `id`'",
+	"newline":          `NAME="line1\nline2\n"`,
+	"carriage return":  `NAME="line1\r\nline2"`,
+	"unicode":          `NAME='pässwörd ✓ 密码 🔑'`,
+	"smart quotes":     `NAME='‘left’ “right”'`,
+	"percent and hash": `NAME='100% #not-a-comment !bang'`,
+	"shell operators":  `NAME='a;b|c&d>e<f^g'`,
+	"mixed quotes":     `NAME="'\"'\"' \"a&b\" ‛x‚ \\'"`,
+}
+
+// dotenvLossyValues are the hostile values that each parser didn't read back from dotenvHostileLines
+// as they were written, with what it read instead.  Every other value was read back as it was
+var dotenvLossyValues = map[string]map[string]string{
+	"godotenv": {
+		// Fails with "unterminated quoted value", as the closing quote follows a backslash
+		"backslash": "",
+	},
+	"docker compose": {},
+	"python-dotenv": {
+		// ${PATH} is expanded even in single quotes (PATH was unset when recorded)
+		"dollar": "$HOME  $(id) `id`",
+	},
+}
+
+// parseDotenvLine parses a dotenv line with docker compose's quoting rules: single quoted values are
+// taken as they are, and in double quoted values \n and \r are expanded and any other escaped
+// character is taken literally.  godotenv and python-dotenv differ, see dotenvLossyValues
+func parseDotenvLine(t *testing.T, line string) (string, string) {
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 || len(parts[1]) < 2 {
+		t.Fatalf("Invalid dotenv line %q", line)
+	}
+
+	quote := parts[1][0]
+	if (quote != '\'' && quote != '"') || parts[1][len(parts[1])-1] != quote {
+		t.Fatalf("Invalid dotenv line %q", line)
+	}
+
+	quoted := parts[1][1 : len(parts[1])-1]
+	if quote == '\'' {
+		if strings.ContainsAny(quoted, "'\\\r\n") {
+			t.Fatalf("Unsafe character in single quoted dotenv line %q", line)
+		}
+		return parts[0], quoted
+	}
+
+	var value strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		c := quoted[i]
+		switch {
+		case c == '\\' && i+1 < len(quoted):
+			i++
+			switch quoted[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				value.WriteByte(quoted[i])
+			}
+		case c == '"' || c == '$' || c == '\n' || c == '\r' || c == '\\':
+			t.Fatalf("Unescaped %q in dotenv line %q", c, line)
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return parts[0], value.String()
+}
+
+func TestDotenvRoundTrip(t *testing.T) {
+	for name, value := range hostileValues {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeEnvVars(&out, FormatDotenv, false, []envVar{{name: "NAME", value: value}}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("Expected a single line, got %q", out.String())
+			}
+
+			// The lossy values were recorded from the lines that were written at the time
+			if lines[0] != dotenvHostileLines[name] {
+				t.Errorf("Expected %s, as read by the dotenv parsers, got %s", dotenvHostileLines[name], lines[0])
+			}
+
+			if _, lossy := dotenvLossyValues["docker compose"][name]; lossy {
+				return
+			}
+			envName, parsed := parseDotenvLine(t, lines[0])
+			if envName != "NAME" || parsed != value {
+				t.Errorf("Expected NAME=%q, got %s=%q", value, envName, parsed)
+			}
+		})
+	}
+
+	for parser, lossyValues := range dotenvLossyValues {
+		for name := range lossyValues {
+			if _, ok := hostileValues[name]; !ok {
+				t.Errorf("Unknown hostile value %q recorded as lossy for %s", name, parser)
+			}
+		}
+	}
+}
+
+// dotenvLineTests are the dotenv lines written for values, single quoted unless they need escaping
+var dotenvLineTests = []struct {
+	value string
+	line  string
+}{
+	{value: "hunter2", line: `NAME='hunter2'`},
+	{value: "", line: `NAME=''`},
+	{value: `say "hi"`, line: `NAME='say "hi"'`},
+	{value: "it's", line: `NAME="it's"`},
+	{value: `C:\path`, line: `NAME="C:\\path"`},
+	{value: "line1\nline2\n", line: `NAME="line1\nline2\n"`},
+	{value: "line1\r\nline2", line: `NAME="line1\r\nline2"`},
+	{value: "100% #not-a-comment !bang", line: `NAME='100% #not-a-comment !bang'`},
+	{value: "$HOME $(id) `id`", line: "NAME='$HOME $(id) `id`'"},
+	// python-dotenv expands ${NAME} even in single quoted values
+	{value: "${PATH}", line: `NAME='${PATH}'`},
+	// python-dotenv doesn't unescape \$
+	{value: "it's $HOME", line: `NAME="it's \$HOME"`},
+	// godotenv can't read double quoted values ending in a backslash or double quote
+	{value: `C:\path\`, line: `NAME="C:\\path\\"`},
+	{value: `it's "hi"`, line: `NAME="it's \"hi\""`},
+}
+
+func TestDotenvLines(t *testing.T) {
+	for _, test := range dotenvLineTests {
+		t.Run(test.value, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeEnvVars(&out, FormatDotenv, false, []envVar{{name: "NAME", value: test.value}}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != test.line+"\n" {
+				t.Errorf("Expected %q, got %q", test.line+"\n", out.String())
+			}
+		})
+	}
+}
+
+func TestDisplayEnvExportsFormat(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"user": "app", "password": "hunter2"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Format: FormatDotenv})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "DB_PASSWORD": "password"}})
+
+	var out bytes.Buffer
+	v2e.SetOutput(&out)
+	if err := v2e.DisplayEnvExports(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "DB_PASSWORD='hunter2'\nDB_USER='app'\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Format: "xml"})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user"}})
+
+	var out bytes.Buffer
+	v2e.SetOutput(&out)
+	err := v2e.DisplayEnvExports()
+	if err == nil || err.Error() != "Unknown output format 'xml'" {
+		t.Fatalf("Expected unknown format error, got %v", err)
+	}
+	if len(fv.requests) != 0 {
+		t.Errorf("Expected no requests to Vault, got %d", len(fv.requests))
+	}
+}
+
+// hostileEnvVars returns an env var for each hostile value, in a fixed order
+func hostileEnvVars() []envVar {
+	var envVars []envVar
+	for _, name := range sortedKeys(hostileValues) {
+		envVars = append(envVars, envVar{name: strings.ToUpper(strings.Replace(name, " ", "_", -1)), value: hostileValues[name]})
+	}
+	return envVars
+}
+
+func TestJSONFormat(t *testing.T) {
+	envVars := hostileEnvVars()
+
+	var out bytes.Buffer
+	if err := writeEnvVars(&out, FormatJSON, false, envVars); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("Invalid JSON output %q: %v", out.String(), err)
+	}
+	if len(parsed) != len(envVars) {
+		t.Errorf("Expected %d env vars, got %d", len(envVars), len(parsed))
+	}
+
+	// The keys are in output order, rather than sorted
+	lastIndex := -1
+	for _, env := range envVars {
+		if parsed[env.name] != env.value {
+			t.Errorf("Expected %s=%q, got %q", env.name, env.value, parsed[env.name])
+		}
+		index := strings.Index(out.String(), `"`+env.name+`":`)
+		if index < lastIndex {
+			t.Errorf("Expected %s to be in output order", env.name)
+		}
+		lastIndex = index
+	}
+}
+
+func TestJSONFormatLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		envVars  []envVar
+		expected string
+	}{
+		{name: "empty", expected: "{}\n"},
+		{
+			name:     "not sorted",
+			envVars:  []envVar{{name: "B", value: "<b>"}, {name: "A", value: "a&a"}},
+			expected: "{\n  \"B\": \"<b>\",\n  \"A\": \"a&a\"\n}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeEnvVars(&out, FormatJSON, false, test.envVars); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestYAMLFormat(t *testing.T) {
+	envVars := hostileEnvVars()
+
+	var out bytes.Buffer
+	if err := writeEnvVars(&out, FormatYAML, false, envVars); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var parsed yaml.MapSlice
+	if err := yaml.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("Invalid YAML output %q: %v", out.String(), err)
+	}
+	if len(parsed) != len(envVars) {
+		t.Fatalf("Expected %d env vars, got %d", len(envVars), len(parsed))
+	}
+	for i, env := range envVars {
+		if parsed[i].Key != env.name || parsed[i].Value != env.value {
+			t.Errorf("Expected %s=%q, got %v=%q", env.name, env.value, parsed[i].Key, parsed[i].Value)
+		}
+	}
+}
+
+func TestMetadataFormats(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv2/": kv2Mount, "database/": databaseMount})
+	fv.kv2Secret("kv2/app", 1, map[string]interface{}{"password": "hunter2"})
+	fv.dynamicSecret("database/creds/app", "database/creds/app/lease", map[string]interface{}{"username": "app-user"})
+
+	expected := []envVarMetadata{
+		{Name: "PASSWORD", Value: "hunter2", SecretPath: "kv2/app", Key: "password", KVVersion: 2},
+		{Name: "DB_USER", Value: "app-user", SecretPath: "database/creds/app", Key: "username", LeaseID: "database/creds/app/lease", LeaseDuration: 3600},
+	}
+
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		t.Run(format, func(t *testing.T) {
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Format: format, Metadata: true})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(
+				&SecretItem{SecretPath: "kv2/app", SecretMaps: map[string]string{"PASSWORD": "password"}},
+				&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}},
+			)
+
+			var out bytes.Buffer
+			v2e.SetOutput(&out)
+			if err := v2e.DisplayEnvExports(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var parsed []envVarMetadata
+			var err error
+			if format == FormatJSON {
+				err = json.Unmarshal(out.Bytes(), &parsed)
+			} else {
+				err = yaml.Unmarshal(out.Bytes(), &parsed)
+			}
+			if err != nil {
+				t.Fatalf("Invalid output %q: %v", out.String(), err)
+			}
+			if !reflect.DeepEqual(parsed, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, parsed)
+			}
+		})
+	}
+}
+
+func TestMetadataFieldNames(t *testing.T) {
+	envVars := []envVar{{name: "DB_USER", value: "app-user", key: "username", secretPath: "database/creds/app", secretItem: &SecretItem{
+		SecretPath: "database/creds/app",
+		secret:     &VaultApi.Secret{LeaseID: "lease", LeaseDuration: 60},
+	}}}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: FormatJSON, expected: `[
+  {
+    "name": "DB_USER",
+    "value": "app-user",
+    "vault_path": "database/creds/app",
+    "key": "username",
+    "lease_id": "lease",
+    "lease_duration": 60
+  }
+]
+`},
+		{format: FormatYAML, expected: `- name: DB_USER
+  value: app-user
+  secretPath: database/creds/app
+  key: username
+  leaseId: lease
+  leaseDuration: 60
+`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeEnvVars(&out, test.format, true, envVars); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestMetadataUnsupportedFormat(t *testing.T) {
+	err := writeEnvVars(&bytes.Buffer{}, FormatDotenv, true, nil)
+	if err == nil || err.Error() != "Metadata can only be output in the json and yaml formats" {
+		t.Errorf("Expected metadata format error, got %v", err)
+	}
+}
+
+func TestShellDialects(t *testing.T) {
+	tests := []struct {
+		format   string
+		value    string
+		expected string
+	}{
+		{format: FormatFish, value: "hunter2", expected: "set -gx NAME 'hunter2'\n"},
+		{format: FormatFish, value: "it's", expected: `set -gx NAME 'it\'s'` + "\n"},
+		{format: FormatFish, value: `C:\path\`, expected: `set -gx NAME 'C:\\path\\'` + "\n"},
+		{format: FormatFish, value: "$HOME (id)\n", expected: "set -gx NAME '$HOME (id)\n'\n"},
+		{format: FormatPowerShell, value: "hunter2", expected: "$Env:NAME = 'hunter2'\n"},
+		{format: FormatPowerShell, value: "it's", expected: "$Env:NAME = 'it''s'\n"},
+		{format: FormatPowerShell, value: "‘left’ ‚low‛", expected: "$Env:NAME = '‘‘left’’ ‚‚low‛‛'\n"},
+		{format: FormatPowerShell, value: "“double” $HOME `n", expected: "$Env:NAME = '“double” $HOME `n'\n"},
+		{format: FormatPowerShell, value: "line1\nline2", expected: "$Env:NAME = 'line1\nline2'\n"},
+		{format: FormatCmd, value: "hunter2", expected: "set \"NAME=hunter2\"\r\n"},
+		{format: FormatCmd, value: "a&b|c<d>e^f(g)", expected: "set \"NAME=a&b|c<d>e^f(g)\"\r\n"},
+		{format: FormatCmd, value: "100%PATH%", expected: "set \"NAME=100%%PATH%%\"\r\n"},
+		{format: FormatCmd, value: `say "a&b" & c`, expected: `set "NAME=say "a^&b" & c"` + "\r\n"},
+		{format: FormatCmd, value: `"&`, expected: `set "NAME="^&"` + "\r\n"},
+		{format: FormatCmd, value: "pässwörd ✓", expected: "set \"NAME=pässwörd ✓\"\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format+" "+test.value, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeEnvVars(&out, test.format, false, []envVar{{name: "NAME", value: test.value}}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, out.String())
+			}
+		})
+	}
+}
+
+// Leases are revoked if a value can't be output in the format
+func TestDisplayEnvExportsRevokesOnFormatError(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.dynamicSecret("database/creds/app", "database/creds/app/lease", map[string]interface{}{"username": "app\nuser"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Format: FormatCmd})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+	v2e.SetOutput(&bytes.Buffer{})
+
+	err := v2e.DisplayEnvExports()
+	if err == nil || err.Error() != "Cannot output env var DB_USER: Values containing line breaks are not supported by the cmd format" {
+		t.Fatalf("Expected line break error, got %v", err)
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease")) != 1 {
+		t.Errorf("Expected the lease to be revoked")
+	}
+}
+
+// unquoteFish parses a fish single quoted string, in which only \\ and \' are escapes
+func unquoteFish(t *testing.T, quoted string) string {
+	var value strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		switch {
+		case quoted[i] == '\\' && i+1 < len(quoted) && (quoted[i+1] == '\\' || quoted[i+1] == '\''):
+			i++
+		case quoted[i] == '\'':
+			t.Fatalf("Unescaped quote in %q", quoted)
+		}
+		value.WriteByte(quoted[i])
+	}
+	return value.String()
+}
+
+// unquotePowerShell parses a PowerShell single quoted string, in which any of the single quote
+// characters is escaped by a second single quote character
+func unquotePowerShell(t *testing.T, quoted string) string {
+	isQuote := func(c rune) bool {
+		return c == '\'' || (c >= '\u2018' && c <= '\u201B')
+	}
+
+	runes := []rune(quoted)
+	var value strings.Builder
+	for i := 0; i < len(runes); i++ {
+		if isQuote(runes[i]) {
+			if i+1 >= len(runes) || !isQuote(runes[i+1]) {
+				t.Fatalf("Unescaped quote in %q", quoted)
+			}
+			i++
+		}
+		value.WriteRune(runes[i])
+	}
+	return value.String()
+}
+
+// unquoteCmd parses the value of a cmd.exe set "NAME=value" statement.  Outside of quotes,
+// unescaped special characters would be interpreted by cmd.exe
+func unquoteCmd(t *testing.T, quoted string) string {
+	var value strings.Builder
+	inQuotes := true
+	for i := 0; i < len(quoted); i++ {
+		c := quoted[i]
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '%':
+			if i+1 >= len(quoted) || quoted[i+1] != '%' {
+				t.Fatalf("Unescaped %% in %q", quoted)
+			}
+			i++
+		case !inQuotes && c == '^':
+			i++
+			c = quoted[i]
+		case !inQuotes && strings.IndexByte("&|<>()", c) >= 0:
+			t.Fatalf("Unescaped %q outside quotes in %q", c, quoted)
+		}
+		value.WriteByte(c)
+	}
+	return value.String()
+}
+
+func TestShellDialectsRoundTrip(t *testing.T) {
+	tests := []struct {
+		format  string
+		prefix  string
+		suffix  string
+		unquote func(*testing.T, string) string
+	}{
+		{format: FormatFish, prefix: "set -gx NAME '", suffix: "'\n", unquote: unquoteFish},
+		{format: FormatPowerShell, prefix: "$Env:NAME = '", suffix: "'\n", unquote: unquotePowerShell},
+		{format: FormatCmd, prefix: `set "NAME=`, suffix: "\"\r\n", unquote: unquoteCmd},
+	}
+
+	for _, test := range tests {
+		for name, value := range hostileValues {
+			t.Run(test.format+" "+name, func(t *testing.T) {
+				var out bytes.Buffer
+				err := writeEnvVars(&out, test.format, false, []envVar{{name: "NAME", value: value}})
+				if test.format == FormatCmd && strings.ContainsAny(value, "\r\n") {
+					if err == nil || err.Error() != "Cannot output env var NAME: Values containing line breaks are not supported by the cmd format" {
+						t.Errorf("Expected line break error, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+
+				line := out.String()
+				if !strings.HasPrefix(line, test.prefix) || !strings.HasSuffix(line, test.suffix) {
+					t.Fatalf("Unexpected output %q", line)
+				}
+				parsed := test.unquote(t, strings.TrimSuffix(strings.TrimPrefix(line, test.prefix), test.suffix))
+				if parsed != value {
+					t.Errorf("Expected %q, got %q", value, parsed)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"strings"
+
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// lease is the lease of a dynamic secret acquired while loading secrets
+type lease struct {
+	id        string
+	namespace string
+	path      string
+	secret    *VaultApi.Secret
+	increment int // TTL to renew the lease to, 0 for the default
+}
+
+// trackLease records the lease of the secret item's secret, if it has one
+func (v *VaultToEnvs) trackLease(secretItem *SecretItem) {
+	if secretItem.secret.LeaseID == "" {
+		return
+	}
+
+	v.leases = append(v.leases, &lease{
+		id:        secretItem.secret.LeaseID,
+		namespace: secretItem.namespace,
+		path:      secretItem.SecretPath,
+		secret:    secretItem.secret,
+		increment: secretItem.TTL,
+	})
+}
+
+// RevokeLeases revokes the leases of all dynamic secrets acquired while loading secrets
+// Leases that fail to revoke are kept so that revoking can be retried
+func (v *VaultToEnvs) RevokeLeases() error {
+	var err error
+	v.leases, err = v.revokeLeases(v.vaultClient, v.leases)
+	return err
+}
+
+// revokeLeases revokes the leases with the client, returning those that failed to revoke
+func (v *VaultToEnvs) revokeLeases(client *VaultApi.Client, leases []*lease) ([]*lease, error) {
+
+	var failed []*lease
+	var errs []string
+	for _, l := range leases {
+		v.log.Info("Revoking lease for ", l.path, ": ", l.id)
+		client.SetNamespace(l.namespace)
+		err := client.Sys().Revoke(l.id)
+		if err != nil {
+			failed = append(failed, l)
+			errs = append(errs, fmt.Sprintf("%s: %s", l.id, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return failed, fmt.Errorf("Error revoking leases: %s", strings.Join(errs, "; "))
+	}
+
+	return nil, nil
+}
+
+// revokeOnFailure revokes all acquired leases after loading secrets failed with err
+// Returns the original error, along with any errors revoking the leases
+func (v *VaultToEnvs) revokeOnFailure(err error) error {
+	if len(v.leases) == 0 {
+		return err
+	}
+
+	v.log.Warn(fmt.Sprintf("Loading secrets failed, revoking %d lease(s)", len(v.leases)))
+	revokeErr := v.RevokeLeases()
+	if revokeErr != nil {
+		return fmt.Errorf("%v\n%v", err, revokeErr)
+	}
+
+	return err
+}
@@ -0,0 +1,94 @@
+package vaulttoenvs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRevokeLeasesOnFailure(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount, "secret/": genericMount})
+	for _, role := range []string{"app", "reporting"} {
+		fv.dynamicSecret("database/creds/"+role, "database/creds/"+role+"/lease", map[string]interface{}{"username": role + "-user"})
+	}
+	fv.genericSecret("secret/app", map[string]interface{}{"password": "hunter2"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(
+		&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}},
+		&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "password"}},
+		&SecretItem{SecretPath: "database/creds/reporting", SecretMaps: map[string]string{"REPORTING_USER": "missing"}},
+	)
+
+	_, err := v2e.GetEnvs()
+	if err == nil || !strings.Contains(err.Error(), "Key missing not found") {
+		t.Fatalf("Expected missing key error, got %v", err)
+	}
+
+	for _, role := range []string{"app", "reporting"} {
+		if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/"+role+"/lease")) != 1 {
+			t.Errorf("Expected lease for %s to be revoked", role)
+		}
+	}
+	if len(v2e.leases) != 0 {
+		t.Errorf("Expected no leases left, got %d", len(v2e.leases))
+	}
+}
+
+func TestRevokeLeasesErrorsReported(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount, "secret/": genericMount})
+	fv.respond("GET", "database/creds/app", 200, map[string]interface{}{
+		"lease_id":       "database/creds/app/lease",
+		"lease_duration": 3600,
+		"renewable":      true,
+		"data":           map[string]interface{}{"username": "app-user"},
+	})
+	fv.respond("PUT", "sys/leases/revoke/database/creds/app/lease", 500, map[string]interface{}{"errors": []string{"backend unavailable"}})
+	fv.genericSecret("secret/app", map[string]interface{}{"password": "hunter2"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(
+		&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}},
+		&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "missing"}},
+	)
+
+	_, err := v2e.GetEnvs()
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if !strings.Contains(err.Error(), "Key missing not found in secret secret/app") {
+		t.Errorf("Expected the original error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Error revoking leases: database/creds/app/lease") || !strings.Contains(err.Error(), "backend unavailable") {
+		t.Errorf("Expected the revoke error, got %v", err)
+	}
+	if len(v2e.leases) != 1 {
+		t.Errorf("Expected the failed lease to be kept, got %d", len(v2e.leases))
+	}
+}
+
+func TestNoRevokeOnSuccess(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.dynamicSecret("database/creds/app", "database/creds/app/lease", map[string]interface{}{"username": "app-user"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease")) != 0 {
+		t.Errorf("Expected lease not to be revoked")
+	}
+	if len(v2e.leases) != 1 || v2e.leases[0].id != "database/creds/app/lease" {
+		t.Errorf("Expected lease to be tracked, got %v", v2e.leases)
+	}
+}
@@ -0,0 +1,125 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultMaxDepth is how deep recursive items read secrets if max_depth isn't set
+const defaultMaxDepth = 10
+
+// getRecursiveSecrets reads every secret in the KV version 2 tree under the path of a recursive
+// item, mapping the keys of each to env vars named from its path relative to the item
+func (v *VaultToEnvs) getRecursiveSecrets(secretItem *SecretItem) error {
+
+	if secretItem.kvVersion != 2 {
+		return fmt.Errorf("Recursive secret %s is not in a KV version 2 secrets engine", secretItem.SecretPath)
+	}
+
+	// Create the metadata path of the tree, relative to the mount
+	treePath := strings.Trim(strings.TrimPrefix(strings.TrimPrefix(secretItem.SecretPath, "/"), secretItem.mountPath), "/")
+	treePath = strings.TrimPrefix(strings.TrimPrefix(treePath, "data/"), "metadata/")
+
+	maxDepth := secretItem.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxDepth
+	}
+
+	v.log.Info("Listing secrets under: ", secretItem.SecretPath)
+	leaves, err := v.listSecrets(secretItem, treePath, "", maxDepth)
+	if err != nil {
+		return err
+	}
+	if len(leaves) == 0 {
+		return fmt.Errorf("No secrets found under %s", secretItem.SecretPath)
+	}
+
+	for _, leaf := range leaves {
+		leafPath := path.Join(secretItem.SecretPath, leaf)
+		v.log.Info("Fetching secret: ", leafPath)
+		secret, err := v.vaultClient.Logical().Read(path.Join(secretItem.mountPath, "data", treePath, leaf))
+		if err != nil {
+			return fmt.Errorf("Error fetching secret: %s", err.Error())
+		}
+		if secret == nil {
+			return fmt.Errorf("Could not find secret %s", leafPath)
+		}
+
+		// The latest version of a secret that has been deleted has no data, but is still listed
+		if secret.Data["data"] == nil {
+			v.log.Warn(fmt.Sprintf("Latest version of secret %s has been deleted, skipping it", leafPath))
+			continue
+		}
+
+		err = mapKeys(secretItem, secret.Data["data"].(map[string]interface{}), leaf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRecursive checks the options of a recursive secret item
+func validateRecursive(label string, secretItem *SecretItem) []string {
+
+	var problems []string
+
+	if len(secretItem.SecretMaps) > 0 {
+		problems = append(problems, fmt.Sprintf("%s: set cannot be used with recursive, as every key is exported", label))
+	}
+	if secretItem.AllKeys {
+		problems = append(problems, fmt.Sprintf("%s: %s cannot be used with recursive, which already exports every key", label, fieldName(secretItem, "AllKeys")))
+	}
+	if secretItem.Version != 0 {
+		problems = append(problems, fmt.Sprintf("%s: version cannot be used with recursive, the latest version of each secret is read", label))
+	}
+	if secretItem.MaxDepth < 0 {
+		problems = append(problems, fmt.Sprintf("%s: %s cannot be negative", label, fieldName(secretItem, "MaxDepth")))
+	}
+
+	return problems
+}
+
+// listSecrets returns the paths of the secrets under a folder (relative to the tree of the item)
+// in sorted order, listing sub-folders up to the max depth
+func (v *VaultToEnvs) listSecrets(secretItem *SecretItem, treePath string, folder string, maxDepth int) ([]string, error) {
+
+	secret, err := v.vaultClient.Logical().List(path.Join(secretItem.mountPath, "metadata", treePath, folder))
+	if err != nil {
+		return nil, fmt.Errorf("Error listing secrets under %s: %s", path.Join(secretItem.SecretPath, folder), err.Error())
+	}
+	if secret == nil || secret.Data["keys"] == nil {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, key := range secret.Data["keys"].([]interface{}) {
+		keys = append(keys, key.(string))
+	}
+	sort.Strings(keys)
+
+	var leaves []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			leaves = append(leaves, path.Join(folder, key))
+			continue
+		}
+
+		// Secrets in the sub-folder are one level deeper than the folder's own secrets
+		subFolder := path.Join(folder, key)
+		if strings.Count(subFolder, "/")+1 >= maxDepth {
+			return nil, fmt.Errorf("Secrets under %s/ are deeper than the max depth of %d of recursive secret %s", path.Join(secretItem.SecretPath, subFolder), maxDepth, secretItem.SecretPath)
+		}
+
+		subLeaves, err := v.listSecrets(secretItem, treePath, subFolder, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, subLeaves...)
+	}
+
+	return leaves, nil
+}
@@ -0,0 +1,313 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecursive(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     *SecretItem
+		expected []string
+	}{
+		{
+			name: "recursive",
+			item: &SecretItem{SecretPath: "kv/myservice", Recursive: true},
+			expected: []string{
+				"DATABASE_PRIMARY_HOST=db1",
+				"DATABASE_PRIMARY_PASSWORD=db1-pass",
+				"DATABASE_REPLICA_HOST=db2",
+				"OAUTH_CLIENT_ID=client",
+				"OAUTH_CLIENT_SECRET=shh",
+				"REDIS_PASSWORD=redis-pass",
+			},
+		},
+		{
+			name:     "prefix and case",
+			item:     &SecretItem{SecretPath: "kv/myservice/", Recursive: true, Prefix: "svc_", Case: CaseLower, Include: []string{"redis/*", "oauth/*"}},
+			expected: []string{"svc_oauth_client_id=client", "svc_oauth_client_secret=shh", "svc_redis_password=redis-pass"},
+		},
+		{
+			name:     "include and exclude",
+			item:     &SecretItem{SecretPath: "kv/myservice", Recursive: true, Include: []string{"database/*/*"}, Exclude: []string{"*/*/password"}},
+			expected: []string{"DATABASE_PRIMARY_HOST=db1", "DATABASE_REPLICA_HOST=db2"},
+		},
+		{
+			name:     "data path",
+			item:     &SecretItem{SecretPath: "kv/data/myservice/database", Recursive: true, Prefix: "DB_"},
+			expected: []string{"DB_PRIMARY_HOST=db1", "DB_PRIMARY_PASSWORD=db1-pass", "DB_REPLICA_HOST=db2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+			fv.kv2List("kv/myservice", "redis", "database/", "oauth")
+			fv.kv2List("kv/myservice/database", "primary", "replica")
+			fv.kv2Secret("kv/myservice/redis", 1, map[string]interface{}{"password": "redis-pass"})
+			fv.kv2Secret("kv/myservice/oauth", 1, map[string]interface{}{"clientId": "client", "clientSecret": "shh"})
+			fv.kv2Secret("kv/myservice/database/primary", 1, map[string]interface{}{"host": "db1", "password": "db1-pass"})
+			fv.kv2Secret("kv/myservice/database/replica", 1, map[string]interface{}{"host": "db2"})
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(test.item)
+
+			envs, err := v2e.GetEnvs()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(envs, test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, envs)
+			}
+		})
+	}
+}
+
+// The source of each env var is the secret in the tree it was read from
+func TestRecursiveSource(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+	fv.kv2List("kv/myservice", "redis", "database/")
+	fv.kv2List("kv/myservice/database", "primary")
+	fv.kv2Secret("kv/myservice/redis", 1, map[string]interface{}{"password": "redis-pass"})
+	fv.kv2Secret("kv/myservice/database/primary", 1, map[string]interface{}{"host": "db1"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Format: FormatJSON, Metadata: true})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "kv/myservice", Recursive: true})
+
+	envVars, err := v2e.GetEnvVars()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []EnvVar{
+		{Name: "DATABASE_PRIMARY_HOST", Value: "db1", Source: "kv/myservice/database/primary"},
+		{Name: "REDIS_PASSWORD", Value: "redis-pass", Source: "kv/myservice/redis"},
+	}
+	if !reflect.DeepEqual(envVars, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, envVars)
+	}
+
+	var out bytes.Buffer
+	v2e.SetOutput(&out)
+	if err := v2e.DisplayEnvExports(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var metadata []envVarMetadata
+	if err := json.Unmarshal(out.Bytes(), &metadata); err != nil {
+		t.Fatalf("Invalid output %q: %v", out.String(), err)
+	}
+	if len(metadata) != 2 || metadata[0].SecretPath != "kv/myservice/database/primary" || metadata[1].SecretPath != "kv/myservice/redis" {
+		t.Errorf("Expected the secret paths in the tree, got %+v", metadata)
+	}
+}
+
+func TestRecursiveMaxDepth(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+	fv.kv2List("kv/myservice", "redis", "database/")
+	fv.kv2List("kv/myservice/database", "primary", "shards/")
+
+	tests := []struct {
+		maxDepth int
+		error    string
+	}{
+		{maxDepth: 1, error: "Secrets under kv/myservice/database/ are deeper than the max depth of 1 of recursive secret kv/myservice"},
+		{maxDepth: 2, error: "Secrets under kv/myservice/database/shards/ are deeper than the max depth of 2 of recursive secret kv/myservice"},
+	}
+
+	for _, test := range tests {
+		v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+		v2e.SetVaultToken("token")
+		v2e.AddSecretItems(&SecretItem{SecretPath: "kv/myservice", Recursive: true, MaxDepth: test.maxDepth})
+
+		if _, err := v2e.GetEnvs(); err == nil || err.Error() != test.error {
+			t.Errorf("Expected error %q, got %v", test.error, err)
+		}
+	}
+
+	// The tree is only listed as deep as the max depth
+	if len(fv.requestsTo("LIST", "kv/metadata/myservice/database/shards")) != 0 {
+		t.Errorf("Expected folders beyond the max depth not to be listed")
+	}
+}
+
+func TestRecursiveCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []*SecretItem
+		setup   func(fv *fakeVault)
+		error   string
+		problem string
+	}{
+		{
+			name: "within the tree",
+			setup: func(fv *fakeVault) {
+				fv.kv2List("kv/myservice", "redis", "Redis")
+				fv.kv2Secret("kv/myservice/Redis", 1, map[string]interface{}{"password": "a"})
+			},
+			items: []*SecretItem{{SecretPath: "kv/myservice", Recursive: true}},
+			error: "Key password of secret kv/myservice/redis gives env var REDIS_PASSWORD, which is already set by key Redis/password",
+		},
+		{
+			name: "with another item",
+			setup: func(fv *fakeVault) {
+				fv.kv2List("kv/myservice", "redis")
+				fv.genericSecret("secret/app", map[string]interface{}{"password": "secret-pass"})
+			},
+			items:   []*SecretItem{{SecretPath: "secret/app", SecretMaps: map[string]string{"REDIS_PASSWORD": "password"}}, {SecretPath: "kv/myservice", Recursive: true}},
+			problem: "item 2 (kv/myservice): env var REDIS_PASSWORD is already set by item 1 (secret/app) (set override to replace it)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := newFakeVault(t)
+			defer fv.Close()
+			fv.mounts(map[string]interface{}{"kv/": kv2Mount, "secret/": kv1Mount})
+			fv.kv2Secret("kv/myservice/redis", 1, map[string]interface{}{"password": "redis-pass"})
+			test.setup(fv)
+
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(test.items...)
+
+			_, err := v2e.GetEnvs()
+			if test.problem != "" {
+				verr, ok := err.(*ValidationError)
+				if !ok || len(verr.Problems) != 1 || verr.Problems[0] != test.problem {
+					t.Fatalf("Expected problem %q, got %v", test.problem, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.error {
+				t.Errorf("Expected error %q, got %v", test.error, err)
+			}
+		})
+	}
+}
+
+// Secrets whose latest version has been deleted are still listed, but are skipped
+func TestRecursiveDeletedSecret(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+	fv.kv2List("kv/myservice", "redis", "oauth")
+	fv.kv2Secret("kv/myservice/redis", 1, map[string]interface{}{"password": "redis-pass"})
+	fv.respond("GET", "kv/data/myservice/oauth", 404, map[string]interface{}{
+		"data": map[string]interface{}{"data": nil, "metadata": map[string]interface{}{"version": 2, "deletion_time": "2019-01-01T00:00:00Z"}},
+	})
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "kv/myservice", Recursive: true})
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(envs, []string{"REDIS_PASSWORD=redis-pass"}) {
+		t.Errorf("Expected the deleted secret to be skipped, got %q", envs)
+	}
+	if !logger.contains("warn: Latest version of secret kv/myservice/oauth has been deleted, skipping it") {
+		t.Errorf("Expected the deleted secret to be logged, got %v", logger.messages)
+	}
+}
+
+func TestRecursiveEmpty(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"kv/": kv2Mount})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "kv/otherservice", Recursive: true})
+
+	if _, err := v2e.GetEnvs(); err == nil || err.Error() != "No secrets found under kv/otherservice" {
+		t.Errorf("Expected no secrets error, got %v", err)
+	}
+}
+
+func TestValidateRecursive(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		problems []string
+	}{
+		{
+			name:   "valid",
+			config: `[{"vault_path": "kv/myservice", "recursive": true, "max_depth": 2, "prefix": "APP_", "exclude": ["legacy/*"]}]`,
+		},
+		{
+			name:   "valid yaml",
+			config: "- secretPath: kv/myservice\n  recursive: true\n  maxDepth: 2\n",
+		},
+		{
+			name:   "invalid options",
+			config: `[{"vault_path": "kv/myservice", "recursive": true, "all_keys": true, "version": 2, "max_depth": -1, "set": {"PASSWORD": "password"}}]`,
+			problems: []string{
+				"item 1 (kv/myservice): set cannot be used with recursive, as every key is exported",
+				"item 1 (kv/myservice): all_keys cannot be used with recursive, which already exports every key",
+				"item 1 (kv/myservice): version cannot be used with recursive, the latest version of each secret is read",
+				"item 1 (kv/myservice): max_depth cannot be negative",
+			},
+		},
+		{
+			name:     "max depth without recursive",
+			config:   `[{"vault_path": "kv/myservice", "max_depth": 2, "set": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 1 (kv/myservice): max_depth can only be used with recursive"},
+		},
+		{
+			name:     "dynamic secret",
+			config:   `[{"vault_path": "aws/creds/app", "recursive": true}]`,
+			problems: []string{"item 1 (aws/creds/app): recursive can only be used on KV version 2 secrets"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewVaultToEnvs(&Config{SecretConfig: test.config}).ValidateConfig()
+			if test.problems == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Expected a validation error, got %v", err)
+			}
+			if strings.Join(verr.Problems, "\n") != strings.Join(test.problems, "\n") {
+				t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(test.problems, "\n"), strings.Join(verr.Problems, "\n"))
+			}
+		})
+	}
+}
+
+// Recursive items can only read KV version 2 secrets, which is checked against the mount
+func TestRecursiveKVVersion1(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": kv1Mount})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/myservice", Recursive: true})
+
+	_, err := v2e.GetEnvs()
+	if err == nil || !strings.Contains(err.Error(), "item 1 (secret/myservice): recursive can only be used on KV version 2 secrets (mount secret/ is KV version 1)") {
+		t.Errorf("Expected KV version error, got %v", err)
+	}
+}
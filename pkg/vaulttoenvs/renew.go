@@ -0,0 +1,221 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"time"
+
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// Policies for when a lease reaches its max TTL, see Config.MaxTTLPolicy
+const (
+	MaxTTLWarn    = "warn"    // log a warning and leave the command running (the default)
+	MaxTTLSignal  = "signal"  // send the command SIGTERM
+	MaxTTLRestart = "restart" // stop the command and restart it with fresh secrets
+)
+
+// renewRetryDelay is how long to wait before renewing again after a renewal fails
+var renewRetryDelay = 10 * time.Second
+
+// leaseExpiry reports that a lease, or the Vault token if lease is nil, has reached its max TTL, or
+// has expired because renewing it kept failing until its lease duration passed (with err the last
+// renewal error)
+type leaseExpiry struct {
+	lease *lease
+	err   error
+}
+
+// checkMaxTTLPolicy returns an error if the max TTL policy isn't supported
+func checkMaxTTLPolicy(policy string) error {
+	switch policy {
+	case "", MaxTTLWarn, MaxTTLSignal, MaxTTLRestart:
+		return nil
+	}
+	return fmt.Errorf("Unknown max TTL policy '%s'", policy)
+}
+
+// renewLeases keeps renewing the Vault token and every renewable lease until stopped, logging each
+// renewal.  The token is renewed too, as Vault revokes leases along with the token that created them
+// Leases (and the token) that reach their max TTL are sent on the returned channel.  Failed renewals
+// are only logged and retried after renewRetryDelay, as they are usually brief network errors, until
+// the lease expires, when it's sent on the channel too
+func (v *VaultToEnvs) renewLeases() (<-chan leaseExpiry, func(), error) {
+
+	expired := make(chan leaseExpiry, len(v.leases)+1)
+	stopCh := make(chan struct{})
+
+	stop := func() {
+		close(stopCh)
+	}
+
+	// Renewers run concurrently, so each needs its own client for the namespace of what it renews
+	startRenewer := func(l *lease, namespace string, input *VaultApi.RenewerInput) error {
+		client, err := v.vaultClient.Clone()
+		if err != nil {
+			return fmt.Errorf("Error creating Vault client for renewing leases: %s", err.Error())
+		}
+		client.SetToken(v.vaultClient.Token())
+		client.SetNamespace(namespace)
+
+		renewer, err := client.NewRenewer(input)
+		if err != nil {
+			return err
+		}
+
+		go v.keepRenewing(l, client, input, renewer, expired, stopCh)
+		return nil
+	}
+
+	tokenSecret, err := v.tokenSecret()
+	if err != nil {
+		v.log.Warn(err.Error())
+	} else if tokenSecret != nil {
+		err = startRenewer(nil, v.config.Namespace, &VaultApi.RenewerInput{Secret: tokenSecret})
+		if err != nil {
+			stop()
+			return nil, nil, fmt.Errorf("Error renewing Vault token: %s", err.Error())
+		}
+	}
+
+	for _, l := range v.leases {
+		if !l.secret.Renewable {
+			v.log.Debug("Lease for ", l.path, " is not renewable")
+			continue
+		}
+
+		err := startRenewer(l, l.namespace, &VaultApi.RenewerInput{
+			Secret:    l.secret,
+			Increment: l.increment,
+		})
+		if err != nil {
+			stop()
+			return nil, nil, fmt.Errorf("Error renewing lease for %s: %s", l.path, err.Error())
+		}
+	}
+
+	return expired, stop, nil
+}
+
+// tokenSecret looks up the Vault token and returns it as the auth of a secret, for renewing it
+// Returns nil if the token doesn't expire or can't be renewed
+func (v *VaultToEnvs) tokenSecret() (*VaultApi.Secret, error) {
+
+	v.vaultClient.SetNamespace(v.config.Namespace)
+	secret, err := v.vaultClient.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up Vault token, it will not be renewed: %s", err.Error())
+	}
+
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Vault token TTL, it will not be renewed: %s", err.Error())
+	}
+	if ttl == 0 {
+		v.log.Debug("Vault token does not expire")
+		return nil, nil
+	}
+
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil || !renewable {
+		v.log.Debug("Vault token is not renewable")
+		return nil, nil
+	}
+
+	return &VaultApi.Secret{
+		Auth: &VaultApi.SecretAuth{
+			ClientToken:   v.vaultClient.Token(),
+			Renewable:     true,
+			LeaseDuration: int(ttl.Seconds()),
+		},
+	}, nil
+}
+
+// revokeToken revokes the token of the client once a new one has been logged in with, only logging
+// any error as the token is no longer used
+func (v *VaultToEnvs) revokeToken(client *VaultApi.Client) {
+	v.log.Info("Revoking Vault token")
+	client.SetNamespace(v.config.Namespace)
+	if err := client.Auth().Token().RevokeSelf(""); err != nil {
+		v.log.Warn(fmt.Sprintf("Error revoking Vault token: %s", err.Error()))
+	}
+}
+
+// keepRenewing runs the renewer for a lease (or the Vault token if l is nil) until renewing is
+// stopped or it reaches its max TTL, starting a new renewer whenever a renewal fails until the
+// lease expires
+func (v *VaultToEnvs) keepRenewing(l *lease, client *VaultApi.Client, input *VaultApi.RenewerInput, renewer *VaultApi.Renewer, expired chan<- leaseExpiry, stopCh <-chan struct{}) {
+
+	// Once the lease duration has passed since it was last renewed, the lease is gone and can't be renewed
+	expiresAt := time.Now().Add(leaseDuration(input.Secret))
+
+	for {
+		go renewer.Renew()
+		stopped, err := v.watchRenewer(l, renewer, stopCh, &expiresAt)
+		if stopped {
+			return
+		}
+		if err == nil {
+			expired <- leaseExpiry{lease: l}
+			return
+		}
+
+		// Don't wait past the expiry to retry
+		delay := renewRetryDelay
+		if remaining := time.Until(expiresAt); remaining < delay {
+			delay = remaining
+		}
+
+		if l == nil {
+			v.log.Warn(fmt.Sprintf("Error renewing Vault token, retrying in %s: %s", delay.Round(time.Millisecond), err.Error()))
+		} else {
+			v.log.Warn(fmt.Sprintf("Error renewing lease for %s, retrying in %s: %s", l.path, delay.Round(time.Millisecond), err.Error()))
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(delay):
+		}
+
+		if !time.Now().Before(expiresAt) {
+			expired <- leaseExpiry{lease: l, err: err}
+			return
+		}
+
+		// Input has already been checked when creating the first renewer
+		renewer, _ = client.NewRenewer(input)
+	}
+}
+
+// watchRenewer logs the renewals of a lease (or the Vault token if l is nil), recording when the
+// renewed lease expires, until renewing is stopped or the renewer is done, returning its error (nil
+// once the max TTL is reached)
+func (v *VaultToEnvs) watchRenewer(l *lease, renewer *VaultApi.Renewer, stopCh <-chan struct{}, expiresAt *time.Time) (bool, error) {
+	for {
+		select {
+		case <-stopCh:
+			renewer.Stop()
+			return true, nil
+		case err := <-renewer.DoneCh():
+			return false, err
+		case renewal := <-renewer.RenewCh():
+			if renewal.Secret == nil {
+				continue
+			}
+			*expiresAt = renewal.RenewedAt.Add(leaseDuration(renewal.Secret))
+			if l == nil && renewal.Secret.Auth != nil {
+				v.log.Info(fmt.Sprintf("Renewed Vault token; Duration: %d", renewal.Secret.Auth.LeaseDuration))
+			} else if l != nil {
+				v.log.Info(fmt.Sprintf("Renewed lease for %s: %s; Duration: %d", l.path, l.id, renewal.Secret.LeaseDuration))
+			}
+		}
+	}
+}
+
+// leaseDuration returns the lease duration of a secret, or of its token if it's the auth of one
+func leaseDuration(secret *VaultApi.Secret) time.Duration {
+	if secret.Auth != nil {
+		return time.Duration(secret.Auth.LeaseDuration) * time.Second
+	}
+	return time.Duration(secret.LeaseDuration) * time.Second
+}
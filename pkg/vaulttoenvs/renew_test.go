@@ -0,0 +1,660 @@
+//go:build !windows
+// +build !windows
+
+package vaulttoenvs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForFile waits for a command to create the file, so that it is ready to be signalled
+func waitForFile(path string) {
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// renewVault returns a fake Vault with a database secrets engine issuing a new lease and username
+// on each read.  Renewing a lease returns the lease duration from renewDuration, which is passed
+// the number of the lease (starting at 1)
+func renewVault(t *testing.T, renewDuration func(leaseNumber int) int) *fakeVault {
+	fv := newFakeVault(t)
+	fv.mounts(map[string]interface{}{
+		"database/": map[string]interface{}{"type": "database"},
+	})
+
+	var mu sync.Mutex
+	leases := 0
+	fv.handle("GET", "database/creds/app", func(fakeRequest) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		leases++
+		return 200, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/lease%d", leases),
+			"lease_duration": 3,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("user%d", leases)},
+		}
+	})
+	fv.handle("PUT", "sys/leases/renew", func(req fakeRequest) (int, interface{}) {
+		var leaseNumber int
+		fmt.Sscanf(req.Body["lease_id"].(string), "database/creds/app/lease%d", &leaseNumber)
+		return 200, map[string]interface{}{
+			"lease_id":       req.Body["lease_id"],
+			"lease_duration": renewDuration(leaseNumber),
+			"renewable":      true,
+		}
+	})
+	for i := 1; i <= 3; i++ {
+		fv.respond("PUT", fmt.Sprintf("sys/leases/revoke/database/creds/app/lease%d", i), 204, nil)
+	}
+
+	// Like a root token, the token doesn't expire
+	fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{
+		"data": map[string]interface{}{"ttl": 0, "renewable": false},
+	})
+	return fv
+}
+
+// tokenVault returns a fake Vault with a secret, and a renewable token with a TTL of 3 seconds
+// Renewing the token returns the lease duration from renewDuration
+func tokenVault(t *testing.T, renewDuration int) *fakeVault {
+	fv := genericSecretVault(newFakeVault(t))
+	fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{
+		"data": map[string]interface{}{"ttl": 3, "renewable": true},
+	})
+	fv.respond("PUT", "auth/token/renew-self", 200, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": "token", "lease_duration": renewDuration, "renewable": true},
+	})
+	return fv
+}
+
+// runWithLeases runs the script with a database credential in DB_USER, using the max TTL policy
+func runWithLeases(t *testing.T, fv *fakeVault, policy string, script string, args ...string) (int, *testLogger) {
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: policy})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	exitCode, err := v2e.Run(append([]string{"sh", "-c", script, "sh"}, args...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return exitCode, logger
+}
+
+func TestRenewLeases(t *testing.T) {
+	fv := renewVault(t, func(int) int { return 3 })
+	defer fv.Close()
+
+	exitCode, logger := runWithLeases(t, fv, MaxTTLWarn, "sleep 1")
+	if exitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", exitCode)
+	}
+
+	renewals := fv.requestsTo("PUT", "sys/leases/renew")
+	if len(renewals) < 1 {
+		t.Fatalf("Expected the lease to be renewed")
+	}
+	if renewals[0].Body["lease_id"] != "database/creds/app/lease1" || renewals[0].Token != "token" {
+		t.Errorf("Unexpected renewal %+v", renewals[0])
+	}
+	if !logger.contains("Renewed lease for database/creds/app: database/creds/app/lease1; Duration: 3") {
+		t.Errorf("Expected the renewal to be logged, got %v", logger.messages)
+	}
+	if logger.contains("max TTL") {
+		t.Errorf("Expected the lease not to reach its max TTL, got %v", logger.messages)
+	}
+}
+
+func TestRenewLeasesTTL(t *testing.T) {
+	fv := renewVault(t, func(int) int { return 3 })
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", TTL: 3, SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	if _, err := v2e.Run([]string{"sleep", "1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The first renewal sets the TTL when loading secrets, the rest keep renewing to it
+	renewals := fv.requestsTo("PUT", "sys/leases/renew")
+	if len(renewals) < 2 {
+		t.Fatalf("Expected the lease to be renewed, got %d renewals", len(renewals))
+	}
+	for _, renewal := range renewals {
+		if renewal.Body["increment"] != float64(3) {
+			t.Errorf("Expected renewal to the TTL, got %v", renewal.Body["increment"])
+		}
+	}
+}
+
+func TestMaxTTLWarn(t *testing.T) {
+	fv := renewVault(t, func(int) int { return 0 })
+	defer fv.Close()
+
+	exitCode, logger := runWithLeases(t, fv, MaxTTLWarn, "sleep 1; exit 4")
+	if exitCode != 4 {
+		t.Errorf("Expected exit code 4, got %d", exitCode)
+	}
+	if !logger.contains("warn: Lease for database/creds/app has reached its max TTL and will expire") {
+		t.Errorf("Expected a max TTL warning, got %v", logger.messages)
+	}
+}
+
+func TestMaxTTLSignal(t *testing.T) {
+	readyFile := tempDir(t) + "/ready"
+	fv := renewVault(t, func(int) int {
+		waitForFile(readyFile)
+		return 0
+	})
+	defer fv.Close()
+
+	start := time.Now()
+	exitCode, _ := runWithLeases(t, fv, MaxTTLSignal, `trap 'exit 7' TERM; touch "$1"; sleep 10 & wait`, readyFile)
+	if exitCode != 7 {
+		t.Errorf("Expected the command to exit on SIGTERM with exit code 7, got %d", exitCode)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the command to be signalled")
+	}
+}
+
+func TestMaxTTLRestart(t *testing.T) {
+	outputFile := tempDir(t) + "/output"
+
+	// Only the first lease reaches its max TTL
+	fv := renewVault(t, func(leaseNumber int) int {
+		if leaseNumber == 1 {
+			waitForFile(outputFile)
+			return 0
+		}
+		return 3
+	})
+	defer fv.Close()
+
+	// The first run waits to be stopped, the second exits once it has run
+	script := `trap 'exit 0' TERM
+echo "$DB_USER" >> "$1"
+if [ "$(wc -l < "$1")" -ge 2 ]; then exit 5; fi
+sleep 10 & wait`
+
+	exitCode, logger := runWithLeases(t, fv, MaxTTLRestart, script, outputFile)
+	if exitCode != 5 {
+		t.Errorf("Expected the exit code of the restarted command, got %d", exitCode)
+	}
+
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	if string(output) != "user1\nuser2\n" {
+		t.Errorf("Expected the command to be restarted with new credentials, got %q", output)
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease1")) != 1 {
+		t.Errorf("Expected the old lease to be revoked")
+	}
+	if !logger.contains("Restarting sh with new secrets") {
+		t.Errorf("Expected the restart to be logged, got %v", logger.messages)
+	}
+}
+
+// Secrets are read again with the same token when restarting, as logging in again would fail for
+// single use credentials like a wrapping token
+func TestMaxTTLRestartWrappedToken(t *testing.T) {
+	outputFile := tempDir(t) + "/output"
+	fv := renewVault(t, func(leaseNumber int) int {
+		if leaseNumber == 1 {
+			waitForFile(outputFile)
+			return 0
+		}
+		return 3
+	})
+	defer fv.Close()
+	fv.wrappedResponse(loginResponse("unwrapped-token"))
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: MaxTTLRestart})
+	v2e.SetLogger(logger)
+	v2e.SetAuthMethod(&WrappedTokenAuth{WrappingToken: "wrapping-token"})
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	script := `trap 'exit 0' TERM
+echo "$DB_USER" >> "$1"
+if [ "$(wc -l < "$1")" -ge 2 ]; then exit 5; fi
+sleep 10 & wait`
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", script, "sh", outputFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 5 {
+		t.Errorf("Expected the exit code of the restarted command, got %d", exitCode)
+	}
+
+	if unwraps := fv.requestsTo("PUT", "sys/wrapping/unwrap"); len(unwraps) != 1 {
+		t.Errorf("Expected the wrapping token to be unwrapped once, got %d unwraps", len(unwraps))
+	}
+	reads := fv.requestsTo("GET", "database/creds/app")
+	if len(reads) != 2 || reads[1].Token != "unwrapped-token" {
+		t.Errorf("Expected the secret to be read again with the unwrapped token, got %+v", reads)
+	}
+	if len(fv.requestsTo("PUT", "auth/token/revoke-self")) != 0 {
+		t.Errorf("Expected the token not to be revoked")
+	}
+}
+
+// Once the token reaches its max TTL, restarting logs in again and revokes the old token
+func TestMaxTTLRestartTokenExpired(t *testing.T) {
+	outputFile := tempDir(t) + "/output"
+	fv := renewVault(t, func(int) int { return 3 })
+	defer fv.Close()
+
+	var mu sync.Mutex
+	logins := 0
+	fv.handle("PUT", "auth/approle/login", func(fakeRequest) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		logins++
+		return 200, loginResponse(fmt.Sprintf("approle-token%d", logins))
+	})
+	fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{
+		"data": map[string]interface{}{"ttl": 3, "renewable": true},
+	})
+
+	// Only the first token reaches its max TTL
+	fv.handle("PUT", "auth/token/renew-self", func(req fakeRequest) (int, interface{}) {
+		duration := 3
+		if req.Token == "approle-token1" {
+			waitForFile(outputFile)
+			duration = 0
+		}
+		return 200, map[string]interface{}{"auth": map[string]interface{}{"client_token": req.Token, "lease_duration": duration, "renewable": true}}
+	})
+	fv.respond("PUT", "auth/token/revoke-self", 204, nil)
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: MaxTTLRestart})
+	v2e.SetLogger(&testLogger{})
+	v2e.SetAuthMethod(&AppRoleAuth{RoleID: "role", SecretID: "secret"})
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	script := `trap 'exit 0' TERM
+echo "$DB_USER" >> "$1"
+if [ "$(wc -l < "$1")" -ge 2 ]; then exit 5; fi
+sleep 10 & wait`
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", script, "sh", outputFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 5 {
+		t.Errorf("Expected the exit code of the restarted command, got %d", exitCode)
+	}
+
+	if logins != 2 {
+		t.Errorf("Expected to log in again, got %d logins", logins)
+	}
+	revokes := fv.requestsTo("PUT", "auth/token/revoke-self")
+	if len(revokes) != 1 || revokes[0].Token != "approle-token1" {
+		t.Errorf("Expected the old token to be revoked, got %+v", revokes)
+	}
+	reads := fv.requestsTo("GET", "database/creds/app")
+	if len(reads) != 2 || reads[1].Token != "approle-token2" {
+		t.Errorf("Expected the secret to be read again with the new token, got %+v", reads)
+	}
+}
+
+// Without an auth method, there is no way to replace a token that reaches its max TTL
+func TestMaxTTLRestartTokenExpiredNoAuthMethod(t *testing.T) {
+	fv := tokenVault(t, 0)
+	defer fv.Close()
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: MaxTTLRestart})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", "sleep 1; exit 4"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 4 {
+		t.Errorf("Expected the command not to be restarted, got exit code %d", exitCode)
+	}
+	if !logger.contains("warn: Not restarting sh, as a new Vault token can only be obtained with an auth method") {
+		t.Errorf("Expected a warning, got %v", logger.messages)
+	}
+	if reads := fv.requestsTo("GET", "secret/app"); len(reads) != 1 {
+		t.Errorf("Expected the secret to be read once, got %d reads", len(reads))
+	}
+}
+
+// Wrapping tokens can only be unwrapped once, so a token from one can't be replaced once it expires
+func TestMaxTTLRestartTokenExpiredWrappedToken(t *testing.T) {
+	fv := tokenVault(t, 0)
+	defer fv.Close()
+	fv.wrappedResponse(loginResponse("unwrapped-token"))
+	fv.respond("PUT", "auth/token/revoke-self", 204, nil)
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: MaxTTLRestart})
+	v2e.SetLogger(logger)
+	v2e.SetAuthMethod(&WrappedTokenAuth{WrappingToken: "wrapping-token"})
+	v2e.AddSecretItems(passwordItem())
+
+	exitCode, err := v2e.Run([]string{"sh", "-c", "sleep 1; exit 4"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 4 {
+		t.Errorf("Expected the command not to be restarted, got exit code %d", exitCode)
+	}
+	if !logger.contains("warn: Not restarting sh, as a new Vault token can only be obtained with an auth method that can log in again") {
+		t.Errorf("Expected a warning, got %v", logger.messages)
+	}
+	if unwraps := fv.requestsTo("PUT", "sys/wrapping/unwrap"); len(unwraps) != 1 {
+		t.Errorf("Expected the wrapping token to be unwrapped once, got %d unwraps", len(unwraps))
+	}
+	if len(fv.requestsTo("PUT", "auth/token/revoke-self")) != 0 {
+		t.Errorf("Expected the token not to be revoked")
+	}
+}
+
+// New secrets are read before the command is stopped, so it keeps running if they can't be
+func TestMaxTTLRestartReadFails(t *testing.T) {
+	fv := renewVault(t, func(int) int { return 0 })
+	defer fv.Close()
+
+	var mu sync.Mutex
+	reads := 0
+	fv.handle("GET", "database/creds/app", func(fakeRequest) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads++
+		if reads > 1 {
+			return 500, map[string]interface{}{"errors": []string{"internal error"}}
+		}
+		return 200, map[string]interface{}{
+			"lease_id":       "database/creds/app/lease1",
+			"lease_duration": 3,
+			"renewable":      true,
+			"data":           map[string]interface{}{"username": "user1"},
+		}
+	})
+
+	exitCode, logger := runWithLeases(t, fv, MaxTTLRestart, "sleep 1; exit 4")
+	if exitCode != 4 {
+		t.Errorf("Expected the command to be left running, got exit code %d", exitCode)
+	}
+	if !logger.contains("warn: Not restarting sh, as reading new secrets failed") {
+		t.Errorf("Expected a warning, got %v", logger.messages)
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/revoke/database/creds/app/lease1")) != 0 {
+		t.Errorf("Expected the lease in use not to be revoked")
+	}
+}
+
+func TestMaxTTLRestartKillsCommand(t *testing.T) {
+	stopTimeout = 100 * time.Millisecond
+	defer func() { stopTimeout = 30 * time.Second }()
+
+	outputFile := tempDir(t) + "/output"
+	fv := renewVault(t, func(leaseNumber int) int {
+		if leaseNumber == 1 {
+			waitForFile(outputFile)
+			return 0
+		}
+		return 3
+	})
+	defer fv.Close()
+
+	// The first run ignores SIGTERM, so has to be killed
+	script := `trap '' TERM
+echo "$DB_USER" >> "$1"
+if [ "$(wc -l < "$1")" -ge 2 ]; then exit 0; fi
+sleep 10`
+
+	_, logger := runWithLeases(t, fv, MaxTTLRestart, script, outputFile)
+	if !logger.contains("did not exit after 100ms, killing it") {
+		t.Errorf("Expected the command to be killed, got %v", logger.messages)
+	}
+}
+
+// A failed renewal is retried, rather than applying the max TTL policy
+func TestRenewalErrorRetried(t *testing.T) {
+	renewRetryDelay = 100 * time.Millisecond
+	defer func() { renewRetryDelay = 10 * time.Second }()
+
+	fv := renewVault(t, func(int) int { return 3 })
+	defer fv.Close()
+
+	var mu sync.Mutex
+	renewals := 0
+	fv.handle("PUT", "sys/leases/renew", func(req fakeRequest) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		renewals++
+		if renewals == 1 {
+			return 500, map[string]interface{}{"errors": []string{"connection reset"}}
+		}
+		return 200, map[string]interface{}{"lease_id": req.Body["lease_id"], "lease_duration": 3, "renewable": true}
+	})
+
+	exitCode, logger := runWithLeases(t, fv, MaxTTLSignal, "sleep 1; exit 4")
+	if exitCode != 4 {
+		t.Errorf("Expected the command not to be signalled, got exit code %d", exitCode)
+	}
+	if !logger.contains("warn: Error renewing lease for database/creds/app, retrying in 100ms") {
+		t.Errorf("Expected a renewal warning, got %v", logger.messages)
+	}
+	if !logger.contains("Renewed lease for database/creds/app: database/creds/app/lease1; Duration: 3") {
+		t.Errorf("Expected the renewal to be retried, got %v", logger.messages)
+	}
+	if logger.contains("max TTL") {
+		t.Errorf("Expected the lease not to reach its max TTL, got %v", logger.messages)
+	}
+}
+
+// Once renewals have failed until the lease expires, the max TTL policy is applied
+func TestRenewalErrorUntilExpiry(t *testing.T) {
+	renewRetryDelay = 100 * time.Millisecond
+	defer func() { renewRetryDelay = 10 * time.Second }()
+
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.respond("GET", "database/creds/app", 200, map[string]interface{}{
+		"lease_id":       "database/creds/app/lease1",
+		"lease_duration": 1,
+		"renewable":      true,
+		"data":           map[string]interface{}{"username": "user1"},
+	})
+	fv.respond("PUT", "sys/leases/renew", 400, map[string]interface{}{"errors": []string{"lease not found"}})
+	fv.respond("PUT", "sys/leases/revoke/database/creds/app/lease1", 204, nil)
+	fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{
+		"data": map[string]interface{}{"ttl": 0, "renewable": false},
+	})
+
+	start := time.Now()
+	exitCode, logger := runWithLeases(t, fv, MaxTTLSignal, `trap 'exit 7' TERM; sleep 10 & wait`)
+	if exitCode != 7 {
+		t.Errorf("Expected the command to be signalled, got exit code %d", exitCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be signalled once the lease expired, took %s", elapsed)
+	}
+	if !logger.contains("warn: Lease for database/creds/app has expired, as renewing it failed") {
+		t.Errorf("Expected an expiry warning, got %v", logger.messages)
+	}
+}
+
+func TestUnknownMaxTTLPolicy(t *testing.T) {
+	v2e := NewVaultToEnvs(&Config{MaxTTLPolicy: "ignore"})
+	if _, err := v2e.Run([]string{"true"}); err == nil || err.Error() != "Unknown max TTL policy 'ignore'" {
+		t.Errorf("Expected unknown policy error, got %v", err)
+	}
+}
+
+// The token is renewed as well as the leases, as Vault revokes leases along with their token
+func TestRenewToken(t *testing.T) {
+	fv := tokenVault(t, 3)
+	defer fv.Close()
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	if _, err := v2e.Run([]string{"sleep", "1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	renewals := fv.requestsTo("PUT", "auth/token/renew-self")
+	if len(renewals) < 1 || renewals[0].Token != "token" {
+		t.Fatalf("Expected the token to be renewed, got %+v", renewals)
+	}
+	if !logger.contains("Renewed Vault token; Duration: 3") {
+		t.Errorf("Expected the renewal to be logged, got %v", logger.messages)
+	}
+}
+
+// A token logged in with an auth method is renewed as well
+func TestRenewTokenAuthMethod(t *testing.T) {
+	fv := tokenVault(t, 3)
+	defer fv.Close()
+	fv.respond("PUT", "auth/approle/login", 200, loginResponse("approle-token"))
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetAuthMethod(&AppRoleAuth{RoleID: "role", SecretID: "secret"})
+	v2e.AddSecretItems(passwordItem())
+
+	if _, err := v2e.Run([]string{"sleep", "1"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	renewals := fv.requestsTo("PUT", "auth/token/renew-self")
+	if len(renewals) < 1 || renewals[0].Token != "approle-token" {
+		t.Fatalf("Expected the logged in token to be renewed, got %+v", renewals)
+	}
+}
+
+// The max TTL policy applies to the token reaching its max TTL, as to leases
+func TestRenewTokenMaxTTL(t *testing.T) {
+	readyFile := tempDir(t) + "/ready"
+	fv := tokenVault(t, 0)
+	defer fv.Close()
+	fv.handle("PUT", "auth/token/renew-self", func(fakeRequest) (int, interface{}) {
+		waitForFile(readyFile)
+		return 200, map[string]interface{}{"auth": map[string]interface{}{"client_token": "token", "lease_duration": 0, "renewable": true}}
+	})
+
+	logger := &testLogger{}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, MaxTTLPolicy: MaxTTLSignal})
+	v2e.SetLogger(logger)
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	start := time.Now()
+	exitCode, err := v2e.Run([]string{"sh", "-c", `trap 'exit 7' TERM; touch "$1"; sleep 10 & wait`, "sh", readyFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exitCode != 7 || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the command to be signalled, got exit code %d", exitCode)
+	}
+	if !logger.contains("warn: Vault token has reached its max TTL and will expire") {
+		t.Errorf("Expected a max TTL warning, got %v", logger.messages)
+	}
+}
+
+// Tokens that don't expire, or can't be looked up, aren't renewed
+func TestRenewTokenNotRenewed(t *testing.T) {
+	tests := []struct {
+		name    string
+		lookup  func(fv *fakeVault)
+		message string
+	}{
+		{
+			name: "no ttl",
+			lookup: func(fv *fakeVault) {
+				fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{"data": map[string]interface{}{"ttl": 0, "renewable": false}})
+			},
+			message: "debug: Vault token does not expire",
+		},
+		{
+			name: "not renewable",
+			lookup: func(fv *fakeVault) {
+				fv.respond("GET", "auth/token/lookup-self", 200, map[string]interface{}{"data": map[string]interface{}{"ttl": 60, "renewable": false}})
+			},
+			message: "debug: Vault token is not renewable",
+		},
+		{
+			name: "lookup denied",
+			lookup: func(fv *fakeVault) {
+				fv.respond("GET", "auth/token/lookup-self", 403, map[string]interface{}{"errors": []string{"permission denied"}})
+			},
+			message: "warn: Error looking up Vault token, it will not be renewed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := tokenVault(t, 3)
+			defer fv.Close()
+			test.lookup(fv)
+
+			logger := &testLogger{}
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetLogger(logger)
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(passwordItem())
+
+			if _, err := v2e.Run([]string{"true"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(fv.requestsTo("PUT", "auth/token/renew-self")) != 0 {
+				t.Errorf("Expected the token not to be renewed")
+			}
+			if !logger.contains(test.message) {
+				t.Errorf("Expected %q to be logged, got %v", test.message, logger.messages)
+			}
+		})
+	}
+}
+
+// Leases that aren't renewable are left to expire
+func TestRenewLeasesNotRenewable(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"database/": databaseMount})
+	fv.respond("GET", "database/creds/app", 200, map[string]interface{}{
+		"lease_id":       "database/creds/app/lease",
+		"lease_duration": 3600,
+		"renewable":      false,
+		"data":           map[string]interface{}{"username": "app-user"},
+	})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "database/creds/app", SecretMaps: map[string]string{"DB_USER": "username"}})
+
+	if _, err := v2e.Run([]string{"true"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fv.requestsTo("PUT", "sys/leases/renew")) != 0 {
+		t.Errorf("Expected the lease not to be renewed")
+	}
+}
@@ -0,0 +1,111 @@
+package vaulttoenvs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+)
+
+// vaultCLIConfig is the part of the Vault CLI config file (~/.vault) used for token lookup
+type vaultCLIConfig struct {
+	TokenHelper string `hcl:"token_helper"`
+}
+
+// FindToken looks up a Vault token in the same order as the Vault CLI: the provided token,
+// the VAULT_TOKEN env var, the token file, the token helper configured in ~/.vault
+// (or VAULT_CONFIG_PATH) and finally ~/.vault-token
+// Returns the token along with a description of where it was found
+func FindToken(token string, tokenFile string) (string, string, error) {
+
+	if token != "" {
+		return token, "provided token", nil
+	}
+
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, "VAULT_TOKEN environment variable", nil
+	}
+
+	if tokenFile != "" {
+		token, err := valueOrFile("", tokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("Error reading Vault token file: %v", err)
+		}
+		return token, "token file " + tokenFile, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("No Vault token provided and unable to find home directory: %v", err)
+	}
+
+	helper, err := tokenHelperPath(homeDir)
+	if err != nil {
+		return "", "", err
+	}
+	if helper != "" {
+		token, err := runTokenHelper(helper)
+		if err != nil {
+			return "", "", err
+		}
+		if token != "" {
+			return token, "token helper " + helper, nil
+		}
+	}
+
+	defaultTokenFile := filepath.Join(homeDir, ".vault-token")
+	if _, err := os.Stat(defaultTokenFile); err == nil {
+		token, err := valueOrFile("", defaultTokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("Error reading Vault token file: %v", err)
+		}
+		return token, "token file " + defaultTokenFile, nil
+	}
+
+	return "", "", fmt.Errorf("No Vault token found")
+}
+
+// tokenHelperPath returns the token helper configured in the Vault CLI config file, if any
+func tokenHelperPath(homeDir string) (string, error) {
+
+	configPath := os.Getenv("VAULT_CONFIG_PATH")
+	if configPath == "" {
+		configPath = filepath.Join(homeDir, ".vault")
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading Vault config file '%s': %v", configPath, err)
+	}
+
+	var cliConfig vaultCLIConfig
+	err = hcl.Decode(&cliConfig, string(data))
+	if err != nil {
+		return "", fmt.Errorf("Error parsing Vault config file '%s': %v", configPath, err)
+	}
+
+	return cliConfig.TokenHelper, nil
+}
+
+// runTokenHelper calls the token helper's get command and returns the token
+func runTokenHelper(helper string) (string, error) {
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(helper, "get")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("Error running Vault token helper '%s': %v: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
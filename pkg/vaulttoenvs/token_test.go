@@ -0,0 +1,160 @@
+package vaulttoenvs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setTokenHome points the home directory at an empty temp dir and clears the token env vars
+func setTokenHome(t *testing.T, env testEnv) string {
+	home := tempDir(t)
+	env.set("HOME", home)
+	env.set("VAULT_TOKEN", "")
+	env.set("VAULT_CONFIG_PATH", "")
+	return home
+}
+
+// writeTokenHelper writes an executable token helper that prints token and returns its path
+func writeTokenHelper(t *testing.T, token string) string {
+	helper := filepath.Join(tempDir(t), "helper")
+	script := "#!/bin/sh\n[ \"$1\" = get ] || exit 1\necho " + token + "\n"
+	if err := ioutil.WriteFile(helper, []byte(script), 0700); err != nil {
+		t.Fatalf("Error writing token helper: %v", err)
+	}
+	return helper
+}
+
+func TestFindTokenOrder(t *testing.T) {
+	env := testEnv{}
+	defer env.restore()
+	home := setTokenHome(t, env)
+	tokenFile := writeTempFile(t, "token", "file-token\n")
+	ioutil.WriteFile(filepath.Join(home, ".vault-token"), []byte("home-token"), 0600)
+	ioutil.WriteFile(filepath.Join(home, ".vault"), []byte(`token_helper = "`+writeTokenHelper(t, "helper-token")+`"`), 0600)
+	env.set("VAULT_TOKEN", "env-token")
+
+	tests := []struct {
+		name      string
+		token     string
+		tokenFile string
+		setup     func()
+		expected  string
+		source    string
+	}{
+		{
+			name:      "provided token",
+			token:     "flag-token",
+			tokenFile: tokenFile,
+			expected:  "flag-token",
+			source:    "provided token",
+		},
+		{
+			name:      "env var",
+			tokenFile: tokenFile,
+			expected:  "env-token",
+			source:    "VAULT_TOKEN",
+		},
+		{
+			name:      "token file",
+			tokenFile: tokenFile,
+			setup:     func() { env.set("VAULT_TOKEN", "") },
+			expected:  "file-token",
+			source:    tokenFile,
+		},
+		{
+			name:     "token helper",
+			expected: "helper-token",
+			source:   "token helper",
+		},
+		{
+			name:     "home token file",
+			setup:    func() { ioutil.WriteFile(filepath.Join(home, ".vault"), []byte("# no helper\n"), 0600) },
+			expected: "home-token",
+			source:   filepath.Join(home, ".vault-token"),
+		},
+	}
+
+	// Each test removes the source used by the one before it
+	for _, test := range tests {
+		if test.setup != nil {
+			test.setup()
+		}
+
+		token, source, err := FindToken(test.token, test.tokenFile)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if token != test.expected {
+			t.Errorf("%s: expected token %s, got %s", test.name, test.expected, token)
+		}
+		if !strings.Contains(source, test.source) {
+			t.Errorf("%s: expected source containing '%s', got '%s'", test.name, test.source, source)
+		}
+		if strings.Contains(source, token) {
+			t.Errorf("%s: source '%s' contains the token", test.name, source)
+		}
+	}
+}
+
+func TestFindTokenConfigPath(t *testing.T) {
+	env := testEnv{}
+	defer env.restore()
+	setTokenHome(t, env)
+	configPath := writeTempFile(t, "vault.hcl", `token_helper = "`+writeTokenHelper(t, "helper-token")+`"`)
+	env.set("VAULT_CONFIG_PATH", configPath)
+
+	token, _, err := FindToken("", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token != "helper-token" {
+		t.Errorf("Expected token from helper in VAULT_CONFIG_PATH, got %s", token)
+	}
+}
+
+func TestFindTokenErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenFile string
+		config    string
+		error     string
+	}{
+		{
+			name:  "no token",
+			error: "No Vault token found",
+		},
+		{
+			name:      "missing token file",
+			tokenFile: "/does/not/exist",
+			error:     "Error reading Vault token file",
+		},
+		{
+			name:   "failing token helper",
+			config: `token_helper = "/bin/false"`,
+			error:  "Error running Vault token helper",
+		},
+		{
+			name:   "invalid config",
+			config: `token_helper = "unterminated`,
+			error:  "Error parsing Vault config file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := testEnv{}
+			defer env.restore()
+			home := setTokenHome(t, env)
+			if test.config != "" {
+				ioutil.WriteFile(filepath.Join(home, ".vault"), []byte(test.config), 0600)
+			}
+
+			_, _, err := FindToken("", test.tokenFile)
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("Expected error containing '%s', got %v", test.error, err)
+			}
+		})
+	}
+}
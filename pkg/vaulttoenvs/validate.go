@@ -0,0 +1,206 @@
+package vaulttoenvs
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// envVarNamePattern matches valid (POSIX shell) environment variable names
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidationError lists every problem found in the secret config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid secret config:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+// ValidateConfig checks the secret config and any added secret items without contacting Vault
+// All problems found are returned together as a *ValidationError
+func (v *VaultToEnvs) ValidateConfig() error {
+
+	configItems, problems, err := v.readSecretConfig()
+	if err != nil {
+		return err
+	}
+
+	secretItems := append(append([]*SecretItem{}, v.addedItems...), configItems...)
+	problems = append(problems, validateSecretItems(secretItems, false)...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// validateSecretItems checks secret items for problems that can be found without reading secrets
+// If withMounts is set, the items' mounts have been looked up and are used to check version and ttl,
+// otherwise paths with a "creds" or "sts" segment are assumed to be dynamic secrets
+func validateSecretItems(secretItems []*SecretItem, withMounts bool) []string {
+
+	var problems []string
+	envItems := make(map[string]int)
+
+	for i, secretItem := range secretItems {
+
+		// A null item in the config, such as a stray "-" in a YAML list
+		if secretItem == nil {
+			problems = append(problems, fmt.Sprintf("item %d: empty item", i+1))
+			continue
+		}
+
+		label := itemLabel(i, secretItem)
+
+		if secretItem.SecretPath == "" {
+			problems = append(problems, fmt.Sprintf("%s: %s is required", label, fieldName(secretItem, "SecretPath")))
+		}
+
+		if secretItem.Recursive {
+			problems = append(problems, validateRecursive(label, secretItem)...)
+		} else if len(secretItem.SecretMaps) < 1 && !secretItem.AllKeys {
+			problems = append(problems, fmt.Sprintf("%s: set must contain at least one env var", label))
+		} else if secretItem.MaxDepth != 0 {
+			problems = append(problems, fmt.Sprintf("%s: %s can only be used with recursive", label, fieldName(secretItem, "MaxDepth")))
+		}
+
+		for _, envName := range sortedKeys(secretItem.SecretMaps) {
+			if !envVarNamePattern.MatchString(envName) {
+				problems = append(problems, fmt.Sprintf("%s: invalid env var name '%s'", label, envName))
+			}
+			if secretItem.SecretMaps[envName] == "" {
+				problems = append(problems, fmt.Sprintf("%s: no secret key set for env var %s", label, envName))
+			}
+
+			// Later items can only replace env vars set by earlier items if override is set
+			if previous, ok := envItems[envName]; ok && !secretItem.Override {
+				problems = append(problems, fmt.Sprintf("%s: env var %s is already set by %s (set override to replace it)", label, envName, itemLabel(previous, secretItems[previous])))
+			} else {
+				envItems[envName] = i
+			}
+		}
+
+		problems = append(problems, validateAllKeys(label, secretItem)...)
+
+		if secretItem.TTL < 0 {
+			problems = append(problems, fmt.Sprintf("%s: ttl cannot be negative", label))
+		}
+
+		if secretItem.SecretPath == "" {
+			continue
+		}
+
+		if withMounts {
+			if secretItem.mount == nil {
+				problems = append(problems, fmt.Sprintf("%s: No secrets engine mounted at path of secret %s", label, secretItem.SecretPath))
+				continue
+			}
+
+			kvVersion := mountKVVersion(secretItem.mount)
+			if secretItem.Version != 0 && kvVersion != 2 {
+				problems = append(problems, fmt.Sprintf("%s: version can only be set on KV version 2 secrets (mount %s is %s)", label, secretItem.mountPath, mountDescription(secretItem.mount)))
+			}
+			if secretItem.TTL != 0 && kvVersion != 0 {
+				problems = append(problems, fmt.Sprintf("%s: ttl can only be set on dynamic secrets (mount %s is %s)", label, secretItem.mountPath, mountDescription(secretItem.mount)))
+			}
+			if secretItem.Recursive && kvVersion != 2 {
+				problems = append(problems, fmt.Sprintf("%s: recursive can only be used on KV version 2 secrets (mount %s is %s)", label, secretItem.mountPath, mountDescription(secretItem.mount)))
+			}
+		} else {
+			dynamic := isDynamicPath(secretItem.SecretPath)
+			if secretItem.Version != 0 && dynamic {
+				problems = append(problems, fmt.Sprintf("%s: version can only be set on KV version 2 secrets", label))
+			}
+			// Dynamic secrets aren't only at creds or sts paths (e.g. gcp/key/my-roleset), so ttl on
+			// other paths is left to be checked against the mount
+			if secretItem.TTL != 0 && secretItem.Version != 0 {
+				problems = append(problems, fmt.Sprintf("%s: ttl can only be set on dynamic secrets, but version is set", label))
+			}
+			if secretItem.Recursive && dynamic {
+				problems = append(problems, fmt.Sprintf("%s: recursive can only be used on KV version 2 secrets", label))
+			}
+		}
+	}
+
+	return problems
+}
+
+// itemLabel identifies a secret item in validation problems
+func itemLabel(i int, secretItem *SecretItem) string {
+	if secretItem.SecretPath == "" {
+		return fmt.Sprintf("item %d", i+1)
+	}
+	return fmt.Sprintf("item %d (%s)", i+1, secretItem.SecretPath)
+}
+
+// fieldName returns the name of a SecretItem field in the config the item was parsed from, using
+// the JSON name for items added with AddSecretItems
+func fieldName(secretItem *SecretItem, field string) string {
+	tag := secretItem.configTag
+	if tag == "" {
+		tag = "json"
+	}
+	structField, _ := reflect.TypeOf(SecretItem{}).FieldByName(field)
+	return strings.Split(structField.Tag.Get(tag), ",")[0]
+}
+
+// mountDescription describes the type of a mount in validation problems
+func mountDescription(mount *VaultApi.MountOutput) string {
+	switch mountKVVersion(mount) {
+	case 1:
+		return "KV version 1"
+	case 2:
+		return "KV version 2"
+	}
+	return mount.Type
+}
+
+// isDynamicPath guesses whether a secret path is for a dynamic secret, such as aws/creds/my-role
+func isDynamicPath(secretPath string) bool {
+	for _, part := range strings.Split(secretPath, "/") {
+		if part == "creds" || part == "sts" {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownFields returns a problem for each field in the raw config items that isn't a SecretItem
+// field, using the struct tag (json or yaml) the config was parsed with
+func unknownFields(rawItems []map[string]interface{}, tag string) []string {
+
+	knownFields := make(map[string]bool)
+	itemType := reflect.TypeOf(SecretItem{})
+	for i := 0; i < itemType.NumField(); i++ {
+		if name := strings.Split(itemType.Field(i).Tag.Get(tag), ",")[0]; name != "" {
+			knownFields[name] = true
+		}
+	}
+
+	var problems []string
+	for i, rawItem := range rawItems {
+		for _, field := range sortedKeys(rawItem) {
+			if !knownFields[field] {
+				problems = append(problems, fmt.Sprintf("item %d: unknown field '%s'", i+1, field))
+			}
+		}
+	}
+
+	return problems
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,225 @@
+package vaulttoenvs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		fileName string
+		problems []string
+	}{
+		{
+			name:   "valid json",
+			config: `[{"vault_path": "secret/app", "set": {"PASSWORD": "password"}}, {"vault_path": "aws/creds/app", "ttl": 3600, "set": {"AWS_ACCESS_KEY_ID": "access_key"}}]`,
+		},
+		{
+			name:     "valid yaml",
+			config:   "- secretPath: secret/app\n  version: 2\n  set:\n    PASSWORD: password\n",
+			fileName: "secrets.yaml",
+		},
+		{
+			name:     "unknown json fields",
+			config:   `[{"vault-path": "secret/app", "sets": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 1: unknown field 'sets'", "item 1: unknown field 'vault-path'", "item 1: vault_path is required", "item 1: set must contain at least one env var"},
+		},
+		{
+			name:     "unknown yaml fields",
+			config:   "- secretPath: secret/app\n  sets:\n    PASSWORD: password\n",
+			fileName: "secrets.yaml",
+			problems: []string{"item 1: unknown field 'sets'", "item 1 (secret/app): set must contain at least one env var"},
+		},
+		{
+			name:     "json field names in yaml",
+			config:   "- vault_path: secret/app\n  set:\n    PASSWORD: password\n",
+			fileName: "secrets.yml",
+			problems: []string{"item 1: unknown field 'vault_path'", "item 1: secretPath is required"},
+		},
+		{
+			name:     "yaml field names in problems",
+			config:   "- maxDepth: 2\n  set:\n    PASSWORD: password\n- secretPath: secret/app\n  prefix: APP_\n  set:\n    PASSWORD: password\n  override: true\n- secretPath: kv/tree\n  recursive: true\n  allKeys: true\n  maxDepth: -1\n",
+			fileName: "secrets.yaml",
+			problems: []string{
+				"item 1: secretPath is required",
+				"item 1: maxDepth can only be used with recursive",
+				"item 2 (secret/app): prefix can only be used with allKeys or recursive",
+				"item 3 (kv/tree): allKeys cannot be used with recursive, which already exports every key",
+				"item 3 (kv/tree): maxDepth cannot be negative",
+			},
+		},
+		{
+			name:     "null json item",
+			config:   `[null, {"vault_path": "secret/app", "set": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 1: empty item"},
+		},
+		{
+			name:     "stray yaml list entry",
+			config:   "- secretPath: secret/app\n  set:\n    PASSWORD: password\n-\n",
+			fileName: "secrets.yaml",
+			problems: []string{"item 2: empty item"},
+		},
+		{
+			name:     "duplicate env names",
+			config:   `[{"vault_path": "secret/app", "set": {"PASSWORD": "password"}}, {"vault_path": "secret/other", "set": {"PASSWORD": "password", "USER": "user"}}]`,
+			problems: []string{"item 2 (secret/other): env var PASSWORD is already set by item 1 (secret/app) (set override to replace it)"},
+		},
+		{
+			name:   "override",
+			config: `[{"vault_path": "secret/app", "set": {"PASSWORD": "password"}}, {"vault_path": "secret/other", "override": true, "set": {"PASSWORD": "password"}}]`,
+		},
+		{
+			name:     "override reported against the item it replaced",
+			config:   `[{"vault_path": "secret/a", "set": {"PASSWORD": "password"}}, {"vault_path": "secret/b", "override": true, "set": {"PASSWORD": "password"}}, {"vault_path": "secret/c", "set": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 3 (secret/c): env var PASSWORD is already set by item 2 (secret/b) (set override to replace it)"},
+		},
+		{
+			name:     "yaml override",
+			config:   "- secretPath: secret/app\n  set:\n    PASSWORD: password\n- secretPath: secret/other\n  override: true\n  set:\n    PASSWORD: password\n",
+			fileName: "secrets.yaml",
+		},
+		{
+			name:     "invalid env names",
+			config:   `[{"vault_path": "secret/app", "set": {"1PASSWORD": "password", "MY-USER": "user", "": "key"}}]`,
+			problems: []string{"item 1 (secret/app): invalid env var name ''", "item 1 (secret/app): invalid env var name '1PASSWORD'", "item 1 (secret/app): invalid env var name 'MY-USER'"},
+		},
+		{
+			name:     "empty key",
+			config:   `[{"vault_path": "secret/app", "set": {"PASSWORD": ""}}]`,
+			problems: []string{"item 1 (secret/app): no secret key set for env var PASSWORD"},
+		},
+		{
+			name:     "version on dynamic secret",
+			config:   `[{"vault_path": "aws/creds/app", "version": 2, "set": {"AWS_ACCESS_KEY_ID": "access_key"}}]`,
+			problems: []string{"item 1 (aws/creds/app): version can only be set on KV version 2 secrets"},
+		},
+		{
+			name:     "ttl on versioned secret",
+			config:   `[{"vault_path": "secret/app", "version": 2, "ttl": 60, "set": {"PASSWORD": "password"}}]`,
+			problems: []string{"item 1 (secret/app): ttl can only be set on dynamic secrets, but version is set"},
+		},
+		{
+			name:   "ttl on dynamic secret without a creds segment",
+			config: `[{"vault_path": "gcp/key/my-roleset", "ttl": 3600, "set": {"GOOGLE_CREDENTIALS": "private_key_data"}}]`,
+		},
+		{
+			name:     "negative ttl",
+			config:   `[{"vault_path": "aws/creds/app", "ttl": -1, "set": {"AWS_ACCESS_KEY_ID": "access_key"}}]`,
+			problems: []string{"item 1 (aws/creds/app): ttl cannot be negative"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{SecretConfig: test.config}
+			if test.fileName != "" {
+				config = &Config{SecretConfigFile: writeTempFile(t, test.fileName, test.config)}
+			}
+
+			err := NewVaultToEnvs(config).ValidateConfig()
+			if test.problems == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Expected a validation error, got %v", err)
+			}
+			if strings.Join(verr.Problems, "\n") != strings.Join(test.problems, "\n") {
+				t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(test.problems, "\n"), strings.Join(verr.Problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateConfigParseError(t *testing.T) {
+	err := NewVaultToEnvs(&Config{SecretConfig: `[{"vault_path": 1}]`}).ValidateConfig()
+	if err == nil || !strings.Contains(err.Error(), "Failed to parse secret config field 0.vault_path (line 1, column 17)") {
+		t.Fatalf("Expected parse error, got %v", err)
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &ValidationError{Problems: []string{"first problem", "second problem"}}
+	expected := "Invalid secret config:\n  first problem\n  second problem"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
+
+// The mounts are looked up and every item validated before any secret is read
+func TestValidateBeforeFetch(t *testing.T) {
+	fv := kvVault(t)
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{
+		VaultAddr:    fv.URL,
+		SecretConfig: `[{"vault_path": "kv1/app", "version": 1, "set": {"PASSWORD": "password"}}, {"vault_path": "kv2/app", "ttl": 60, "set": {"USER": "user"}}, {"vault_path": "missing/app", "set": {"OTHER": "password"}}]`,
+	})
+	v2e.SetVaultToken("token")
+
+	_, err := v2e.GetEnvs()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+
+	expected := []string{
+		"item 1 (kv1/app): version can only be set on KV version 2 secrets (mount kv1/ is KV version 1)",
+		"item 2 (kv2/app): ttl can only be set on dynamic secrets (mount kv2/ is KV version 2)",
+		"item 3 (missing/app): No secrets engine mounted at path of secret missing/app",
+	}
+	if strings.Join(verr.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected problems:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(verr.Problems, "\n"))
+	}
+
+	for _, req := range fv.requests {
+		if req.Path != "sys/mounts" {
+			t.Errorf("Expected only the mounts to be read, got %s %s", req.Method, req.Path)
+		}
+	}
+}
+
+// A config that fails to parse is reported before contacting Vault
+func TestParseErrorBeforeLogin(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, SecretConfig: `[{"vault_path": }]`})
+	v2e.SetVaultToken("token")
+
+	if _, err := v2e.GetEnvs(); err == nil || !strings.Contains(err.Error(), "Error parsing secret config") {
+		t.Fatalf("Expected parse error, got %v", err)
+	}
+	if len(fv.requests) != 0 {
+		t.Errorf("Expected no requests to Vault, got %d", len(fv.requests))
+	}
+}
+
+// A null item is reported rather than looked up
+func TestEmptyItemBeforeFetch(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"password": "hunter2"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, SecretConfig: `[{"vault_path": "secret/app", "set": {"PASSWORD": "password"}}, null]`})
+	v2e.SetVaultToken("token")
+
+	_, err := v2e.GetEnvs()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if strings.Join(verr.Problems, "\n") != "item 2: empty item" {
+		t.Errorf("Expected the null item to be reported, got %v", verr.Problems)
+	}
+	if len(fv.requestsTo("GET", "secret/app")) != 0 {
+		t.Errorf("Expected no secrets to be read")
+	}
+}
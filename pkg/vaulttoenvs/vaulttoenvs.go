@@ -1,9 +1,8 @@
 package vaulttoenvs
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path"
 	"sort"
@@ -33,21 +32,65 @@ type SecretItem struct {
 	TTL                int               `json:"ttl" yaml:"ttl"`
 	Version            float64           `json:"version" yaml:"version"`
 	SecretMaps         map[string]string `json:"set" yaml:"set"`
+	Namespace          string            `json:"namespace" yaml:"namespace"` // overrides Config.Namespace
+	Override           bool              `json:"override" yaml:"override"`   // allows replacing env vars set by earlier items
+	AllKeys            bool              `json:"all_keys" yaml:"allKeys"`    // maps every key of the secret to an env var named from the key
+	Prefix             string            `json:"prefix" yaml:"prefix"`       // all keys: prepended to env var names
+	Case               string            `json:"case" yaml:"case"`           // all keys: case transform of keys, see CaseUpper etc. (defaults to CaseUpper)
+	Include            []string          `json:"include" yaml:"include"`     // all keys: only map keys matching one of these glob patterns
+	Exclude            []string          `json:"exclude" yaml:"exclude"`     // all keys: don't map keys matching any of these glob patterns
+	Recursive          bool              `json:"recursive" yaml:"recursive"` // maps every key of every KV version 2 secret under the path, see getRecursiveSecrets
+	MaxDepth           int               `json:"max_depth" yaml:"maxDepth"`  // recursive: how many levels below the path to read (defaults to 10)
 	secretDataPath     string            // kv v2
 	secretMetadataPath string            // kv v2
 	effectiveVersion   int               // kv v2
+	kvVersion          int               // 0 for non-kv mounts
 	secretMapValues    map[string]string
+	secretMapKeys      map[string]string // secret key each env var was read from
+	secretMapPaths     map[string]string // recursive: path of the secret each env var was read from
+	namespace          string            // effective namespace
 	secret             *VaultApi.Secret
 	mount              *VaultApi.MountOutput
+	mountPath          string // path of the mount, with trailing slash
+	configTag          string // struct tag (json or yaml) of the config the item was parsed from
+}
+
+// EnvVar is a secret environment variable
+type EnvVar struct {
+	Name   string
+	Value  string
+	Source string // Vault path of the secret the value was read from
+}
+
+// envVar is a loaded env var, along with the secret item it was read from
+type envVar struct {
+	name       string
+	value      string
+	key        string // key of the secret the value was read from
+	secretPath string // path of the secret the value was read from
+	secretItem *SecretItem
 }
 
 // Config contains the vault-to-env configuration
 type Config struct {
 	VaultAddr        string
 	vaultToken       string
+	authMethod       AuthMethod
 	Debug            bool
 	SecretConfig     string
 	SecretConfigFile string
+	CACert           string // PEM-encoded CA cert file used to verify the Vault server
+	CAPath           string // directory of PEM-encoded CA cert files used to verify the Vault server
+	ClientCert       string // PEM-encoded client cert file for mTLS/cert auth
+	ClientKey        string // PEM-encoded client key file for mTLS/cert auth
+	TLSServerName    string // SNI host name to use when connecting to Vault
+	TLSSkipVerify    bool   // disables verification of the Vault server certificate
+	Namespace        string // Vault Enterprise namespace, used for login and secrets without their own namespace
+	Sort             bool   // sorts the output by env var name, rather than config order
+	Format           string // output format of DisplayEnvExports, see FormatShell etc. (defaults to FormatShell)
+	Metadata         bool   // includes the source and lease of each env var in the json and yaml formats
+	MaxTTLPolicy     string // what Run does when a lease or the token reaches its max TTL, see MaxTTLWarn etc. (defaults to MaxTTLWarn)
+	RevokeOnExit     bool   // revokes the leases once the command run by Run exits
 }
 
 // VaultToEnvs is the main struct for this package
@@ -55,8 +98,11 @@ type VaultToEnvs struct {
 	config           *Config
 	vaultClient      *VaultApi.Client
 	log              log
-	secretMountTypes map[string]*VaultApi.MountOutput
-	secretItems      []*SecretItem
+	secretMountTypes map[string]map[string]*VaultApi.MountOutput // keyed by namespace, then mount path
+	addedItems       []*SecretItem                               // added with AddSecretItems
+	secretItems      []*SecretItem                               // added and secret config items, set when loading secrets
+	leases           []*lease
+	out              io.Writer
 }
 
 // NewVaultToEnvs creates a new VaultToEnvs
@@ -64,6 +110,7 @@ func NewVaultToEnvs(config *Config) *VaultToEnvs {
 	v2e := VaultToEnvs{
 		config: config,
 		log:    log{},
+		out:    os.Stdout,
 	}
 	return &v2e
 }
@@ -73,88 +120,111 @@ func (v *VaultToEnvs) SetLogger(logger Logger) {
 	v.log.logger = logger
 }
 
+// SetOutput sets where DisplayEnvExports writes to, instead of stdout
+func (v *VaultToEnvs) SetOutput(out io.Writer) {
+	v.out = out
+}
+
 // SetVaultToken sets the Vault token
 func (v *VaultToEnvs) SetVaultToken(token string) {
 	v.config.vaultToken = token
 }
 
+// SetAuthMethod sets the method used to log in to Vault.  If set, it takes precedence
+// over the Vault token
+func (v *VaultToEnvs) SetAuthMethod(auth AuthMethod) {
+	v.config.authMethod = auth
+}
+
 func (v *VaultToEnvs) AddSecretItems(items ...*SecretItem) {
-	v.secretItems = append(v.secretItems, items...)
+	v.addedItems = append(v.addedItems, items...)
 }
 
+// loadSecrets logs in to Vault and reads the secrets of every item
 func (v *VaultToEnvs) loadSecrets() error {
+	return v.readSecrets(true)
+}
 
-	var err error
-
-	// Configure new Vault Client
-	conf := &VaultApi.Config{Address: v.config.VaultAddr}
-	v.vaultClient, err = VaultApi.NewClient(conf)
-	if err != nil {
-		return err
-	}
-	v.vaultClient.SetToken(v.config.vaultToken)
-
-	// Pull together the mount types
-	mountOutput, err := v.vaultClient.Sys().ListMounts()
-	if err != nil {
-		return fmt.Errorf("Error fetching mounts: %s", err.Error())
-	}
+// readSecrets reads the secrets of every item, first logging in to Vault if login is set
+// Otherwise the client and token of the last login are used again
+func (v *VaultToEnvs) readSecrets(login bool) (err error) {
 
-	v.secretMountTypes = make(map[string]*VaultApi.MountOutput)
-	for mountPath, mountData := range mountOutput {
-		v.secretMountTypes[mountPath] = mountData
-	}
+	v.leases = nil
 
-	// Open/Parse secret config data
-	var secretConfigData []byte
-	if v.config.SecretConfigFile != "" {
-		file, err := os.Open(v.config.SecretConfigFile)
+	// Revoke any dynamic secrets already issued if a later step fails
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("Error opening config file '%s': %v", v.config.SecretConfigFile, err)
+			err = v.revokeOnFailure(err)
 		}
-		defer file.Close()
+	}()
 
-		secretConfigData, err = ioutil.ReadAll(file)
+	// Parse the secret config before contacting Vault
+	configItems, problems, err := v.readSecretConfig()
+	if err != nil {
+		return err
+	}
+	v.secretItems = append(append([]*SecretItem{}, v.addedItems...), configItems...)
+
+	if login {
+		err = v.login()
 		if err != nil {
-			return fmt.Errorf("Error reading config file '%s': %v", v.config.SecretConfigFile, err)
+			return err
 		}
-	} else if v.config.SecretConfig != "" {
-		secretConfigData = []byte(v.config.SecretConfig)
 	}
 
-	if secretConfigData != nil {
-		var secretItems []*SecretItem
-		err = json.Unmarshal(secretConfigData, &secretItems)
-		if err != nil {
-			if terr, ok := err.(*json.UnmarshalTypeError); ok {
-				return fmt.Errorf("Failed to parse secret config field %s: %v", terr.Field, terr)
-			}
+	v.secretMountTypes = make(map[string]map[string]*VaultApi.MountOutput)
+
+	// Look up the mount of every item, so the whole config can be validated before reading any secrets
+	for _, secretItem := range v.secretItems {
 
-			return fmt.Errorf("Error parsing secret config: %v", err)
+		// Null items have nothing to look up, and are reported by the validation below
+		if secretItem == nil {
+			continue
 		}
-		v.secretItems = append(v.secretItems, secretItems...)
-	}
 
-	// Retrieve the secrets from Vault
-	for i, secretItem := range v.secretItems {
+		// Mount tables differ between namespaces, so look up the mount in the item's namespace
+		secretItem.namespace = secretItem.Namespace
+		if secretItem.namespace == "" {
+			secretItem.namespace = v.config.Namespace
+		}
 
 		if secretItem.SecretPath == "" {
-			return fmt.Errorf("Error: secret_path not specified in secret config for item %d", i+1)
+			continue
 		}
 
-		if len(secretItem.SecretMaps) < 1 {
-			return fmt.Errorf("No env exports set for secret %s", secretItem.SecretPath)
+		v.vaultClient.SetNamespace(secretItem.namespace)
+		mounts, err := v.getMounts(secretItem.namespace)
+		if err != nil {
+			return err
 		}
 
+		secretItem.mountPath, secretItem.mount = findMount(mounts, secretItem.SecretPath)
+	}
+
+	problems = append(problems, validateSecretItems(v.secretItems, true)...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	// Retrieve the secrets from Vault
+	for _, secretItem := range v.secretItems {
+
 		secretItem.secretMapValues = make(map[string]string)
-		pathParts := strings.Split(secretItem.SecretPath, "/")
-		secretItem.mount = v.secretMountTypes[pathParts[0]+"/"]
-		err := v.getSecret(secretItem)
+		secretItem.secretMapKeys = make(map[string]string)
+		secretItem.secretMapPaths = make(map[string]string)
+		v.vaultClient.SetNamespace(secretItem.namespace)
+
+		err = v.getSecret(secretItem)
 		if err != nil {
 			return err
 		}
 	}
 
+	// The env vars of all keys items are only known now, so check again that none collide
+	if problems := checkEnvCollisions(v.secretItems); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
 	// Loop through secretItems and, if the mount has type aws, wait for AWS credentials to become active
 	// TODO: Could probably do this in some sort of multithread manner
 	for _, secretItem := range v.secretItems {
@@ -167,16 +237,102 @@ func (v *VaultToEnvs) loadSecrets() error {
 	}
 
 	// TODO: Zero out the secret from memory
-	// TODO: Revoke dynamic secrets on failure
 
 	return nil
 }
 
+// login creates the Vault client and obtains a token with the auth method if one is configured,
+// otherwise the Vault token is used
+func (v *VaultToEnvs) login() error {
+
+	client, err := v.newVaultClient()
+	if err != nil {
+		return err
+	}
+	v.vaultClient = client
+	v.vaultClient.SetNamespace(v.config.Namespace)
+
+	if v.config.authMethod == nil {
+		v.vaultClient.SetToken(v.config.vaultToken)
+		return nil
+	}
+
+	// The client picks up VAULT_TOKEN from the environment, which must not be sent with the login
+	v.vaultClient.ClearToken()
+	token, err := v.config.authMethod.Login(v.vaultClient)
+	if err != nil {
+		return err
+	}
+	v.vaultClient.SetToken(token)
+
+	return nil
+}
+
+// getMounts returns the mount table for the namespace, fetching it the first time it's needed
+// Expects the client namespace to already be set
+func (v *VaultToEnvs) getMounts(namespace string) (map[string]*VaultApi.MountOutput, error) {
+
+	if mounts, ok := v.secretMountTypes[namespace]; ok {
+		return mounts, nil
+	}
+
+	mounts, err := v.vaultClient.Sys().ListMounts()
+	if err != nil {
+		if namespace != "" {
+			return nil, fmt.Errorf("Error fetching mounts in namespace %s: %s", namespace, err.Error())
+		}
+		return nil, fmt.Errorf("Error fetching mounts: %s", err.Error())
+	}
+
+	v.secretMountTypes[namespace] = mounts
+	return mounts, nil
+}
+
+// findMount returns the mount with the longest path matching the start of the secret path
+// Returns a nil mount if the secret isn't under any mount
+func findMount(mounts map[string]*VaultApi.MountOutput, secretPath string) (string, *VaultApi.MountOutput) {
+
+	secretPath = strings.TrimPrefix(secretPath, "/") + "/"
+
+	var matchPath string
+	var match *VaultApi.MountOutput
+	for mountPath, mount := range mounts {
+		if strings.HasPrefix(secretPath, mountPath) && len(mountPath) > len(matchPath) {
+			matchPath = mountPath
+			match = mount
+		}
+	}
+
+	return matchPath, match
+}
+
+// newVaultClient configures a new Vault client, including TLS settings
+func (v *VaultToEnvs) newVaultClient() (*VaultApi.Client, error) {
+
+	conf := &VaultApi.Config{Address: v.config.VaultAddr}
+	err := conf.ConfigureTLS(&VaultApi.TLSConfig{
+		CACert:        v.config.CACert,
+		CAPath:        v.config.CAPath,
+		ClientCert:    v.config.ClientCert,
+		ClientKey:     v.config.ClientKey,
+		TLSServerName: v.config.TLSServerName,
+		Insecure:      v.config.TLSSkipVerify,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error configuring Vault TLS: %s", err.Error())
+	}
+
+	return VaultApi.NewClient(conf)
+}
+
 func (v *VaultToEnvs) getSecret(secretItem *SecretItem) error {
 
 	var err error
 
-	if secretItem.mount.Type == "kv" {
+	secretItem.kvVersion = mountKVVersion(secretItem.mount)
+	if secretItem.Recursive {
+		return v.getRecursiveSecrets(secretItem)
+	} else if secretItem.kvVersion == 2 {
 		err = v.GetKV2Secret(secretItem)
 		if err != nil {
 			return err
@@ -188,12 +344,6 @@ func (v *VaultToEnvs) getSecret(secretItem *SecretItem) error {
 			return fmt.Errorf("Version specified on non-versioned secret: %s", secretItem.SecretPath)
 		}
 
-		// Add the 'data' subpath if it doesn't exist for v2 secret stores
-		pathParts := strings.Split(secretItem.SecretPath, "/")
-		if secretItem.mount.Type == "kv" && pathParts[1] != "data" {
-			secretItem.SecretPath = path.Join(pathParts[0], "data", strings.Join(pathParts[1:], "/"))
-		}
-
 		// Read the secret from Vault
 		var secret *VaultApi.Secret
 		v.log.Info("Fetching secret: ", secretItem.SecretPath)
@@ -209,11 +359,12 @@ func (v *VaultToEnvs) getSecret(secretItem *SecretItem) error {
 
 		secretItem.secret = secret
 
-		for envName, secretKeyName := range secretItem.SecretMaps {
-			if secret.Data[secretKeyName] == nil {
-				return fmt.Errorf("Key %s not found in secret %s", secretKeyName, secretItem.SecretPath)
-			}
-			secretItem.secretMapValues[envName] = secret.Data[secretKeyName].(string)
+		// Track the lease so it can be revoked if anything after this fails
+		v.trackLease(secretItem)
+
+		err = mapSecretValues(secretItem, secret.Data)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -230,6 +381,7 @@ func (v *VaultToEnvs) getSecret(secretItem *SecretItem) error {
 			return fmt.Errorf("Error renewing secret (setting TTL): %s", err.Error())
 		}
 		v.log.Info("New Lease Info ", renewedSecret.LeaseID, ",", renewedSecret.LeaseDuration)
+		secretItem.secret.LeaseDuration = renewedSecret.LeaseDuration
 
 		// Check if lease duration was able to be set to desired amount
 		// Added some tolerance for any request delay
@@ -241,27 +393,47 @@ func (v *VaultToEnvs) getSecret(secretItem *SecretItem) error {
 	return nil
 }
 
-// DisplayEnvExports outputs the results to stdout
+// mountKVVersion returns the key-value version of the mount, or 0 if it isn't a key-value mount
+// kv mounts report their version in the mount options, with no version meaning version 1
+func mountKVVersion(mount *VaultApi.MountOutput) int {
+	switch mount.Type {
+	case "kv":
+		if mount.Options["version"] == "2" {
+			return 2
+		}
+		return 1
+	case "generic":
+		return 1
+	}
+	return 0
+}
+
+// DisplayEnvExports outputs the results to stdout (or the writer set with SetOutput), in the
+// format set by Config.Format
 func (v *VaultToEnvs) DisplayEnvExports() error {
 
-	err := v.loadSecrets()
+	// Check the format before reading any secrets
+	err := checkFormat(v.config.Format, v.config.Metadata)
 	if err != nil {
 		return err
 	}
 
-	for _, secretItem := range v.secretItems {
-		for envName, secretValue := range secretItem.secretMapValues {
+	err = v.loadSecrets()
+	if err != nil {
+		return err
+	}
 
-			// Prints the env variable line to stdout
-			// Single quotes value and escapes single quotes in secret with '"'"'
-			fmt.Printf("export %s='%s'\n", envName, strings.Replace(secretValue, "'", "'\"'\"'", -1))
-		}
+	// Values that can't be output in the format would otherwise leave the leases issued but unused
+	err = writeEnvVars(v.out, v.config.Format, v.config.Metadata, v.envVars())
+	if err != nil {
+		return v.revokeOnFailure(err)
 	}
 
 	return nil
 }
 
-// GetEnvs returns the secret environment variables as a slice
+// GetEnvs returns the secret environment variables as a slice of NAME=value strings, as used by
+// exec.Cmd.Env.  Values are not quoted or escaped
 func (v *VaultToEnvs) GetEnvs() ([]string, error) {
 	err := v.loadSecrets()
 	if err != nil {
@@ -270,30 +442,109 @@ func (v *VaultToEnvs) GetEnvs() ([]string, error) {
 
 	result := []string{}
 
-	for _, secretItem := range v.secretItems {
-		for envName, secretValue := range secretItem.secretMapValues {
+	for _, env := range v.envVars() {
+		result = append(result, env.name+"="+env.value)
+	}
 
-			// Single quotes value and escapes single quotes in secret with '"'"'
-			result = append(result, fmt.Sprintf("%s=%s", envName, strings.Replace(secretValue, "'", "'\"'\"'", -1)))
-		}
+	return result, nil
+}
+
+// GetEnvVars returns the secret environment variables, along with the secret each was read from
+func (v *VaultToEnvs) GetEnvVars() ([]EnvVar, error) {
+	err := v.loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []EnvVar{}
+
+	for _, env := range v.envVars() {
+		result = append(result, EnvVar{
+			Name:   env.name,
+			Value:  env.value,
+			Source: env.secretPath,
+		})
+	}
+
+	return result, nil
+}
+
+// GetEnvMap returns the secret environment variables as a map of names to values
+func (v *VaultToEnvs) GetEnvMap() (map[string]string, error) {
+	err := v.loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+
+	for _, env := range v.envVars() {
+		result[env.name] = env.value
 	}
 
 	return result, nil
 }
 
+// envVars returns the loaded env vars in config order, with each item's env vars sorted by name
+// Env vars replaced by a later item (with override set) are left out.  If Config.Sort is set,
+// all env vars are sorted by name instead
+func (v *VaultToEnvs) envVars() []envVar {
+
+	var envVars []envVar
+	for i, secretItem := range v.secretItems {
+		for _, envName := range sortedKeys(secretItem.secretMapValues) {
+			if v.isOverridden(i, envName) {
+				continue
+			}
+			secretPath, ok := secretItem.secretMapPaths[envName]
+			if !ok {
+				secretPath = secretItem.SecretPath
+			}
+			envVars = append(envVars, envVar{
+				name:       envName,
+				value:      secretItem.secretMapValues[envName],
+				key:        secretItem.secretMapKeys[envName],
+				secretPath: secretPath,
+				secretItem: secretItem,
+			})
+		}
+	}
+
+	if v.config.Sort {
+		sort.SliceStable(envVars, func(i, j int) bool {
+			return envVars[i].name < envVars[j].name
+		})
+	}
+
+	return envVars
+}
+
+// isOverridden returns true if an env var set by the item at index is also set by a later item,
+// which (having passed validation) must have override set
+func (v *VaultToEnvs) isOverridden(index int, envName string) bool {
+	for _, secretItem := range v.secretItems[index+1:] {
+		if _, ok := secretItem.secretMapValues[envName]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // GetKV2Secret gets a key-value (version 2) secret
 // Uses the `version` option to select the desired version.  This can be negative to go back x versions or positive to indicate
 // the actual secret version
 func (v *VaultToEnvs) GetKV2Secret(secretItem *SecretItem) error {
 
-	// Create the data and metadata paths for the secret
-	pathParts := strings.Split(secretItem.SecretPath, "/")
-	if pathParts[1] != "data" {
-		secretItem.secretDataPath = path.Join(pathParts[0], "data", strings.Join(pathParts[1:], "/"))
-	} else {
-		secretItem.secretDataPath = secretItem.SecretPath
+	// Create the data and metadata paths for the secret, relative to the mount
+	// Assume the mount is the first path segment if it hasn't been looked up
+	mountPath := secretItem.mountPath
+	if mountPath == "" {
+		mountPath = strings.Split(strings.TrimPrefix(secretItem.SecretPath, "/"), "/")[0] + "/"
 	}
-	secretItem.secretMetadataPath = path.Join(pathParts[0], "metadata", strings.Join(pathParts[1:], "/"))
+	secretPath := strings.TrimPrefix(strings.TrimPrefix(secretItem.SecretPath, "/"), mountPath)
+	secretPath = strings.TrimPrefix(secretPath, "data/")
+	secretItem.secretDataPath = path.Join(mountPath, "data", secretPath)
+	secretItem.secretMetadataPath = path.Join(mountPath, "metadata", secretPath)
 
 	// Determine the version to pull
 	if secretItem.Version >= 0 {
@@ -368,21 +619,11 @@ func (v *VaultToEnvs) GetKV2Secret(secretItem *SecretItem) error {
 	secretItem.secret = secret
 
 	// Map the keys to the env values
-	for envName, secretKeyName := range secretItem.SecretMaps {
-		if secret.Data["data"] == nil {
-			return fmt.Errorf("No data found in secret %s", secretItem.SecretPath)
-		}
-
-		data := secret.Data["data"].(map[string]interface{})
-
-		if data[secretKeyName] == nil {
-			return fmt.Errorf("Key %s not found in secret %s", secretKeyName, secretItem.SecretPath)
-		}
-
-		secretItem.secretMapValues[envName] = data[secretKeyName].(string)
+	if secret.Data["data"] == nil {
+		return fmt.Errorf("No data found in secret %s", secretItem.SecretPath)
 	}
 
-	return nil
+	return mapSecretValues(secretItem, secret.Data["data"].(map[string]interface{}))
 }
 
 func (v *VaultToEnvs) waitForAwsCredsToActivate(secretItem *SecretItem) error {
@@ -390,7 +631,7 @@ func (v *VaultToEnvs) waitForAwsCredsToActivate(secretItem *SecretItem) error {
 	// Retrieve ID/Key from secretItem
 	var accessKey string
 	var secretKey string
-	for k, v := range secretItem.SecretMaps {
+	for k, v := range secretItem.secretMapKeys {
 		if v == "access_key" {
 			accessKey = secretItem.secretMapValues[k]
 		} else if v == "secret_key" {
@@ -0,0 +1,589 @@
+package vaulttoenvs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	VaultApi "github.com/hashicorp/vault/api"
+)
+
+// genericSecretVault returns a fake Vault with a single generic secret at secret/app
+func genericSecretVault(fv *fakeVault) *fakeVault {
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"password": "hunter2"})
+	return fv
+}
+
+// passwordItem returns a secret item that reads the password from secret/app
+func passwordItem() *SecretItem {
+	return &SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "password"}}
+}
+
+func TestTLSConfig(t *testing.T) {
+	fv := genericSecretVault(newFakeVaultTLS(t))
+	defer fv.Close()
+	caCert := fv.caCertFile()
+
+	tests := []struct {
+		name   string
+		config Config
+		error  string
+	}{
+		{
+			name:   "CA cert",
+			config: Config{CACert: caCert},
+		},
+		{
+			name:   "CA path",
+			config: Config{CAPath: strings.TrimSuffix(caCert, "/ca.pem")},
+		},
+		{
+			name:   "server name",
+			config: Config{CACert: caCert, TLSServerName: "example.com"},
+		},
+		{
+			name:   "wrong server name",
+			config: Config{CACert: caCert, TLSServerName: "vault.invalid"},
+			error:  "vault.invalid",
+		},
+		{
+			name:   "skip verify",
+			config: Config{TLSSkipVerify: true},
+		},
+		{
+			name:   "unknown CA",
+			config: Config{},
+			error:  "certificate",
+		},
+		{
+			name:   "client cert without key",
+			config: Config{CACert: caCert, ClientCert: caCert},
+			error:  "Error configuring Vault TLS",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := testEnv{}
+			defer env.restore()
+			env.set("VAULT_CACERT", "")
+			env.set("VAULT_CAPATH", "")
+			env.set("VAULT_SKIP_VERIFY", "")
+
+			config := test.config
+			config.VaultAddr = fv.URL
+			v2e := NewVaultToEnvs(&config)
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(passwordItem())
+
+			_, err := v2e.GetEnvs()
+			if test.error == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			} else if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+				t.Errorf("Expected error containing '%s', got %v", test.error, err)
+			}
+		})
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	fv := genericSecretVault(newFakeVaultTLS(t))
+	defer fv.Close()
+	certFile, keyFile := writeClientCert(t, "my-client")
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, CACert: fv.caCertFile(), ClientCert: certFile, ClientKey: keyFile})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem())
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reads := fv.requestsTo("GET", "secret/app")
+	if len(reads) != 1 || reads[0].CertCN != "my-client" {
+		t.Errorf("Expected secret read with the client certificate, got %v", reads)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("PUT", "auth/approle/login", 200, loginResponse("approle-token"))
+	fv.handle("GET", "sys/mounts", func(req fakeRequest) (int, interface{}) {
+		mounts := map[string]interface{}{
+			"team-a": map[string]interface{}{"secret/": map[string]interface{}{"type": "generic"}},
+			"team-b": map[string]interface{}{"other/": map[string]interface{}{"type": "generic"}},
+		}
+		return 200, map[string]interface{}{"data": mounts[req.Namespace]}
+	})
+	fv.respond("GET", "secret/app", 200, map[string]interface{}{
+		"data": map[string]interface{}{"password": "team-a-password"},
+	})
+	fv.respond("GET", "other/app", 200, map[string]interface{}{
+		"data": map[string]interface{}{"password": "team-b-password"},
+	})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Namespace: "team-a"})
+	v2e.SetAuthMethod(&AppRoleAuth{RoleID: "role"})
+	v2e.AddSecretItems(
+		&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"A_PASSWORD": "password"}},
+		&SecretItem{SecretPath: "other/app", SecretMaps: map[string]string{"B_PASSWORD": "password"}, Namespace: "team-b"},
+		&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"A_PASSWORD_AGAIN": "password"}},
+	)
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(envs, ",") != "A_PASSWORD=team-a-password,B_PASSWORD=team-b-password,A_PASSWORD_AGAIN=team-a-password" {
+		t.Errorf("Unexpected envs: %v", envs)
+	}
+
+	if logins := fv.requestsTo("PUT", "auth/approle/login"); len(logins) != 1 || logins[0].Namespace != "team-a" {
+		t.Errorf("Expected login in the global namespace, got %v", logins)
+	}
+
+	mounts := fv.requestsTo("GET", "sys/mounts")
+	if len(mounts) != 2 || mounts[0].Namespace != "team-a" || mounts[1].Namespace != "team-b" {
+		t.Errorf("Expected mounts to be listed once per namespace, got %v", mounts)
+	}
+
+	for _, req := range fv.requestsTo("GET", "secret/app") {
+		if req.Namespace != "team-a" {
+			t.Errorf("Expected secret/app to be read in namespace team-a, got '%s'", req.Namespace)
+		}
+	}
+	if reads := fv.requestsTo("GET", "other/app"); len(reads) != 1 || reads[0].Namespace != "team-b" {
+		t.Errorf("Expected other/app to be read in namespace team-b, got %v", reads)
+	}
+}
+
+func TestNamespaceMountsError(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.respond("GET", "sys/mounts", 403, map[string]interface{}{"errors": []string{"permission denied"}})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"PASSWORD": "password"}, Namespace: "team-b"})
+
+	_, err := v2e.GetEnvs()
+	if err == nil || !strings.Contains(err.Error(), "Error fetching mounts in namespace team-b") {
+		t.Errorf("Expected mounts error for namespace team-b, got %v", err)
+	}
+}
+
+func TestMountKVVersion(t *testing.T) {
+	tests := []struct {
+		mount    VaultApi.MountOutput
+		expected int
+	}{
+		{VaultApi.MountOutput{Type: "kv", Options: map[string]string{"version": "2"}}, 2},
+		{VaultApi.MountOutput{Type: "kv", Options: map[string]string{"version": "1"}}, 1},
+		{VaultApi.MountOutput{Type: "kv"}, 1},
+		{VaultApi.MountOutput{Type: "generic"}, 1},
+		{VaultApi.MountOutput{Type: "aws"}, 0},
+		{VaultApi.MountOutput{Type: "database", Options: map[string]string{"version": "2"}}, 0},
+	}
+
+	for _, test := range tests {
+		if version := mountKVVersion(&test.mount); version != test.expected {
+			t.Errorf("Expected version %d for %s mount with options %v, got %d", test.expected, test.mount.Type, test.mount.Options, version)
+		}
+	}
+}
+
+// kvVault returns a fake Vault with a secret with a password of "<mount>-password" in each flavour of kv mount
+func kvVault(t *testing.T) *fakeVault {
+	fv := newFakeVault(t)
+	fv.mounts(map[string]interface{}{
+		"kv1/":     kv1Mount,
+		"kv2/":     kv2Mount,
+		"kv/":      map[string]interface{}{"type": "kv", "options": nil},
+		"generic/": genericMount,
+	})
+	for _, mount := range []string{"kv1", "kv", "generic"} {
+		fv.genericSecret(mount+"/app", map[string]interface{}{"password": mount + "-password"})
+	}
+	fv.kv2Secret("kv2/app", 3, map[string]interface{}{"password": "kv2-password"})
+
+	// Version 2 has been deleted
+	fv.respond("GET", "kv2/metadata/app", 200, map[string]interface{}{
+		"data": map[string]interface{}{
+			"versions": map[string]interface{}{
+				"1": map[string]interface{}{"deletion_time": "", "destroyed": false},
+				"2": map[string]interface{}{"deletion_time": "2019-01-01T00:00:00Z", "destroyed": false},
+				"3": map[string]interface{}{"deletion_time": "", "destroyed": false},
+			},
+		},
+	})
+	return fv
+}
+
+func TestKVMounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     SecretItem
+		expected string
+		version  int
+		error    string
+	}{
+		{
+			name:     "kv version 1",
+			item:     SecretItem{SecretPath: "kv1/app"},
+			expected: "kv1-password",
+			version:  1,
+		},
+		{
+			name:     "kv without version option",
+			item:     SecretItem{SecretPath: "kv/app"},
+			expected: "kv-password",
+			version:  1,
+		},
+		{
+			name:     "generic",
+			item:     SecretItem{SecretPath: "generic/app"},
+			expected: "generic-password",
+			version:  1,
+		},
+		{
+			name:     "kv version 2",
+			item:     SecretItem{SecretPath: "kv2/app"},
+			expected: "kv2-password",
+			version:  2,
+		},
+		{
+			name:     "kv version 2 with data path",
+			item:     SecretItem{SecretPath: "kv2/data/app"},
+			expected: "kv2-password",
+			version:  2,
+		},
+		{
+			name:     "kv version 2 previous version",
+			item:     SecretItem{SecretPath: "kv2/app", Version: -1},
+			expected: "kv2-password",
+			version:  2,
+		},
+		{
+			name:  "version on kv version 1",
+			item:  SecretItem{SecretPath: "kv1/app", Version: 2},
+			error: "version can only be set on KV version 2 secrets (mount kv1/ is KV version 1)",
+		},
+		{
+			name:  "version on generic",
+			item:  SecretItem{SecretPath: "generic/app", Version: 2},
+			error: "version can only be set on KV version 2 secrets (mount generic/ is KV version 1)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv := kvVault(t)
+			defer fv.Close()
+
+			item := test.item
+			item.SecretMaps = map[string]string{"PASSWORD": "password"}
+			v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+			v2e.SetVaultToken("token")
+			v2e.AddSecretItems(&item)
+
+			envs, err := v2e.GetEnvs()
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Errorf("Expected error containing '%s', got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(envs) != 1 || envs[0] != "PASSWORD="+test.expected {
+				t.Errorf("Expected PASSWORD=%s, got %v", test.expected, envs)
+			}
+			if item.kvVersion != test.version {
+				t.Errorf("Expected kv version %d, got %d", test.version, item.kvVersion)
+			}
+		})
+	}
+}
+
+func TestKVVersion2PreviousVersionSkipsDeleted(t *testing.T) {
+	fv := kvVault(t)
+	defer fv.Close()
+
+	item := &SecretItem{SecretPath: "kv2/app", Version: -1, SecretMaps: map[string]string{"PASSWORD": "password"}}
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(item)
+
+	if _, err := v2e.GetEnvs(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if item.effectiveVersion != 1 {
+		t.Errorf("Expected deleted version 2 to be skipped for version 1, got %d", item.effectiveVersion)
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts := map[string]*VaultApi.MountOutput{
+		"kv/":                {Type: "kv"},
+		"teams/":             {Type: "generic"},
+		"teams/payments/":    {Type: "generic"},
+		"teams/payments/kv/": {Type: "kv"},
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"kv/app", "kv/"},
+		{"/kv/app", "kv/"},
+		{"teams/app", "teams/"},
+		{"teams/payments/app", "teams/payments/"},
+		{"teams/payments/kv/app/db", "teams/payments/kv/"},
+		{"teams/payments/kvx/app", "teams/payments/"},
+		{"kv", "kv/"},
+		{"kvx/app", ""},
+		{"other/app", ""},
+	}
+
+	for _, test := range tests {
+		mountPath, mount := findMount(mounts, test.path)
+		if mountPath != test.expected {
+			t.Errorf("Expected mount %s for %s, got %s", test.expected, test.path, mountPath)
+		}
+		if (mount == nil) != (test.expected == "") || (mount != nil && mount != mounts[test.expected]) {
+			t.Errorf("Unexpected mount %v for %s", mount, test.path)
+		}
+	}
+}
+
+func TestNestedMounts(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{
+		"teams/":             map[string]interface{}{"type": "generic"},
+		"teams/payments/kv/": map[string]interface{}{"type": "kv", "options": map[string]interface{}{"version": "2"}},
+	})
+	fv.respond("GET", "teams/payments/kv/data/app/db", 200, map[string]interface{}{
+		"data": map[string]interface{}{"data": map[string]interface{}{"password": "nested-password"}},
+	})
+	fv.respond("GET", "teams/payments/kv/metadata/app/db", 200, map[string]interface{}{
+		"data": map[string]interface{}{
+			"versions": map[string]interface{}{
+				"1": map[string]interface{}{"deletion_time": "", "destroyed": false},
+				"2": map[string]interface{}{"deletion_time": "", "destroyed": false},
+			},
+		},
+	})
+
+	for _, secretPath := range []string{"teams/payments/kv/app/db", "teams/payments/kv/data/app/db"} {
+		item := &SecretItem{SecretPath: secretPath, Version: -1, SecretMaps: map[string]string{"PASSWORD": "password"}}
+		v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+		v2e.SetVaultToken("token")
+		v2e.AddSecretItems(item)
+
+		envs, err := v2e.GetEnvs()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", secretPath, err)
+		}
+		if len(envs) != 1 || envs[0] != "PASSWORD=nested-password" {
+			t.Errorf("%s: unexpected envs: %v", secretPath, envs)
+		}
+		if item.secretMetadataPath != "teams/payments/kv/metadata/app/db" {
+			t.Errorf("%s: unexpected metadata path %s", secretPath, item.secretMetadataPath)
+		}
+	}
+}
+
+func TestUnknownMount(t *testing.T) {
+	fv := kvVault(t)
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "missing/app", SecretMaps: map[string]string{"PASSWORD": "password"}})
+
+	_, err := v2e.GetEnvs()
+	if err == nil || !strings.Contains(err.Error(), "No secrets engine mounted at path of secret missing/app") {
+		t.Errorf("Expected unknown mount error, got %v", err)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+	fv.respond("GET", "secret/override", 200, map[string]interface{}{
+		"data": map[string]interface{}{"password": "correct-horse"},
+	})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(
+		passwordItem(),
+		&SecretItem{SecretPath: "secret/override", Override: true, SecretMaps: map[string]string{"PASSWORD": "password"}},
+	)
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(envs) != 1 || envs[0] != "PASSWORD=correct-horse" {
+		t.Errorf("Expected the overriding value only, got %v", envs)
+	}
+}
+
+func TestDuplicateEnvRejectedBeforeFetch(t *testing.T) {
+	fv := genericSecretVault(newFakeVault(t))
+	defer fv.Close()
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(passwordItem(), passwordItem())
+
+	_, err := v2e.GetEnvs()
+	if err == nil || !strings.Contains(err.Error(), "env var PASSWORD is already set by item 1 (secret/app)") {
+		t.Fatalf("Expected duplicate env var error, got %v", err)
+	}
+	if len(fv.requestsTo("GET", "secret/app")) != 0 {
+		t.Errorf("Expected no secrets to be read")
+	}
+}
+
+func TestOutputOrder(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"user": "app", "password": "hunter2", "host": "db"})
+	fv.genericSecret("secret/api", map[string]interface{}{"token": "abc", "url": "https://api"})
+
+	tests := []struct {
+		name     string
+		sort     bool
+		expected []string
+	}{
+		{
+			name:     "config order",
+			expected: []string{"DB_HOST=db", "DB_PASSWORD=hunter2", "DB_USER=app", "API_TOKEN=abc", "API_URL=https://api"},
+		},
+		{
+			name:     "sorted",
+			sort:     true,
+			expected: []string{"API_TOKEN=abc", "API_URL=https://api", "DB_HOST=db", "DB_PASSWORD=hunter2", "DB_USER=app"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Map iteration order is random, so repeat to catch any dependence on it
+			for run := 0; run < 20; run++ {
+				v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL, Sort: test.sort})
+				v2e.SetVaultToken("token")
+				v2e.AddSecretItems(
+					&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "DB_PASSWORD": "password", "DB_HOST": "host"}},
+					&SecretItem{SecretPath: "secret/api", SecretMaps: map[string]string{"API_URL": "url", "API_TOKEN": "token"}},
+				)
+
+				envs, err := v2e.GetEnvs()
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if strings.Join(envs, "\n") != strings.Join(test.expected, "\n") {
+					t.Fatalf("Expected:\n%s\ngot:\n%s", strings.Join(test.expected, "\n"), strings.Join(envs, "\n"))
+				}
+			}
+		})
+	}
+}
+
+// An overriding env var is output in the position of the item that overrides it
+func TestOverrideOrder(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"user": "app", "password": "hunter2"})
+	fv.genericSecret("secret/api", map[string]interface{}{"token": "abc", "url": "https://api"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(
+		&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "TOKEN": "password"}},
+		&SecretItem{SecretPath: "secret/api", Override: true, SecretMaps: map[string]string{"TOKEN": "token", "API_URL": "url"}},
+	)
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"DB_USER=app", "API_URL=https://api", "TOKEN=abc"}
+	if strings.Join(envs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(envs, "\n"))
+	}
+}
+
+func TestGetEnvsRawValues(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+
+	// The password would need escaping in a shell
+	fv.genericSecret("secret/app", map[string]interface{}{"password": `it's "$HOME"` + "\n", "user": "app"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "DB_PASSWORD": "password"}})
+
+	envs, err := v2e.GetEnvs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"DB_PASSWORD=it's \"$HOME\"\n", "DB_USER=app"}
+	if !reflect.DeepEqual(envs, expected) {
+		t.Errorf("Expected %q, got %q", expected, envs)
+	}
+}
+
+func TestGetEnvVars(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"password": `it's "$HOME"` + "\n", "user": "app"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "DB_PASSWORD": "password"}})
+
+	envVars, err := v2e.GetEnvVars()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []EnvVar{
+		{Name: "DB_PASSWORD", Value: "it's \"$HOME\"\n", Source: "secret/app"},
+		{Name: "DB_USER", Value: "app", Source: "secret/app"},
+	}
+	if !reflect.DeepEqual(envVars, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, envVars)
+	}
+}
+
+func TestGetEnvMap(t *testing.T) {
+	fv := newFakeVault(t)
+	defer fv.Close()
+	fv.mounts(map[string]interface{}{"secret/": genericMount})
+	fv.genericSecret("secret/app", map[string]interface{}{"password": `it's "$HOME"` + "\n", "user": "app"})
+
+	v2e := NewVaultToEnvs(&Config{VaultAddr: fv.URL})
+	v2e.SetVaultToken("token")
+	v2e.AddSecretItems(&SecretItem{SecretPath: "secret/app", SecretMaps: map[string]string{"DB_USER": "user", "DB_PASSWORD": "password"}})
+
+	envMap, err := v2e.GetEnvMap()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"DB_PASSWORD": "it's \"$HOME\"\n", "DB_USER": "app"}
+	if !reflect.DeepEqual(envMap, expected) {
+		t.Errorf("Expected %q, got %q", expected, envMap)
+	}
+}